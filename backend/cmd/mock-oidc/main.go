@@ -0,0 +1,31 @@
+// ローカル用モック OIDC プロバイダー
+//
+//	go run ./cmd/mock-oidc -addr :9400 -issuer http://localhost:9400
+//
+// バックエンド側は以下のように設定する：
+//
+//	OIDC_ISSUER=http://localhost:9400 OIDC_CLIENT_ID=chat-app
+//	OIDC_REDIRECT_URL=http://localhost:8081/auth/oidc/callback
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"backend/oidc/mockprovider"
+)
+
+func main() {
+	addr := flag.String("addr", ":9400", "待ち受けアドレス")
+	issuer := flag.String("issuer", "http://localhost:9400", "issuer（外部から見える URL）")
+	flag.Parse()
+
+	p, err := mockprovider.New(*issuer)
+	if err != nil {
+		log.Fatal("❌ モックプロバイダーの作成に失敗:", err)
+	}
+
+	log.Println("🚀 モック OIDC プロバイダー起動:", *issuer)
+	log.Fatal(http.ListenAndServe(*addr, p))
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`          // read / messages:post / write
+	RoomID        *int     `json:"room_id"`         // 投稿先ルームの制限（任意）
+	ExpiresInDays int      `json:"expires_in_days"` // 0 = 無期限
+}
+
+type AccessTokenInfo struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	RoomID     *int       `json:"room_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Revoked    bool       `json:"revoked"`
+}
+
+type BotInfo struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// トークン管理はログインセッション（Cookie / JWT）からのみ許可する
+// アクセストークン自身で新しいトークンを発行できないようにするため
+func requireSessionUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return 0, false
+	}
+	if utils.AuthFromRequest(r).IsAccessToken() {
+		http.Error(w, "この操作はアクセストークンでは実行できません", http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
+}
+
+// POST /me/tokens 自分用のアクセストークンを発行
+func (s *Server) CreateAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+	s.createAccessToken(w, r, userID, userID)
+}
+
+// GET /me/tokens 自分のアクセストークン一覧
+func (s *Server) ListAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+	s.listAccessTokens(w, userID)
+}
+
+// POST /me/tokens/{token_id}/revoke トークンを失効（自分または自分のボットのもの）
+func (s *Server) RevokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["token_id"])
+	if err != nil {
+		http.Error(w, "無効な token_id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.DB.Exec(`
+		UPDATE personal_access_tokens t SET revoked_at = NOW()
+		FROM users u
+		WHERE t.id = $1 AND u.id = t.user_id
+		  AND (u.id = $2 OR u.bot_owner_id = $2)
+		  AND t.revoked_at IS NULL
+	`, tokenID, userID)
+	if err != nil {
+		http.Error(w, "トークンの失効に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "トークンが存在しません", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "トークンを失効しました",
+	})
+}
+
+// POST /bots ボットアカウントを作成（作成者がオーナーになる）
+func (s *Server) CreateBotHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Username) == "" {
+		http.Error(w, "ユーザー名を入力してください", http.StatusBadRequest)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+
+	var exists bool
+	if err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`, req.Username).Scan(&exists); err != nil {
+		http.Error(w, "データベース照会に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "ユーザー名は既に存在します", http.StatusConflict)
+		return
+	}
+
+	// ボットはパスワードを持たない（アクセストークンのみで認証）
+	var botID int
+	err := s.DB.QueryRow(`
+		INSERT INTO users (username, password_hash, is_bot, bot_owner_id)
+		VALUES ($1, '', true, $2) RETURNING id
+	`, req.Username, userID).Scan(&botID)
+	if err != nil {
+		http.Error(w, "ボットの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(BotInfo{ID: botID, Username: req.Username})
+}
+
+// GET /bots 自分がオーナーのボット一覧
+func (s *Server) ListBotsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := s.DB.Query(`SELECT id, username FROM users WHERE bot_owner_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		http.Error(w, "ボットの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	bots := []BotInfo{}
+	for rows.Next() {
+		var b BotInfo
+		if err := rows.Scan(&b.ID, &b.Username); err == nil {
+			bots = append(bots, b)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"bots": bots})
+}
+
+// POST /bots/{bot_id}/tokens ボット用のアクセストークンを発行
+func (s *Server) CreateBotTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+	botID, ok := s.ownedBotID(w, r, userID)
+	if !ok {
+		return
+	}
+	s.createAccessToken(w, r, botID, userID)
+}
+
+// GET /bots/{bot_id}/tokens ボットのアクセストークン一覧
+func (s *Server) ListBotTokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+	botID, ok := s.ownedBotID(w, r, userID)
+	if !ok {
+		return
+	}
+	s.listAccessTokens(w, botID)
+}
+
+// URL の bot_id が呼び出しユーザーのボットか確認
+func (s *Server) ownedBotID(w http.ResponseWriter, r *http.Request, ownerID int) (int, bool) {
+	botID, err := strconv.Atoi(mux.Vars(r)["bot_id"])
+	if err != nil {
+		http.Error(w, "無効な bot_id", http.StatusBadRequest)
+		return 0, false
+	}
+	var owned bool
+	err = s.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND is_bot AND bot_owner_id = $2)
+	`, botID, ownerID).Scan(&owned)
+	if err != nil {
+		http.Error(w, "データベース照会に失敗しました", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !owned {
+		http.Error(w, "ボットが存在しません", http.StatusNotFound)
+		return 0, false
+	}
+	return botID, true
+}
+
+// トークンを発行して平文を一度だけ返す
+func (s *Server) createAccessToken(w http.ResponseWriter, r *http.Request, userID, createdBy int) {
+	var req CreateAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "無効なリクエストです", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "トークン名を入力してください", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{utils.ScopeRead}
+	}
+	for _, scope := range req.Scopes {
+		if !utils.ValidScopes[scope] {
+			http.Error(w, "無効なスコープ: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.RoomID != nil {
+		var exists bool
+		if err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM chat_rooms WHERE id = $1)`, *req.RoomID).Scan(&exists); err != nil || !exists {
+			http.Error(w, "ルームが存在していません", http.StatusBadRequest)
+			return
+		}
+		// 発行するユーザー自身が参加しているルームにしか制限できない
+		role, err := s.roomRole(*req.RoomID, createdBy)
+		if err != nil {
+			http.Error(w, "ルームデータの取得に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		if role == "" {
+			http.Error(w, "このルームのメンバーではありません", http.StatusForbidden)
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	token, err := utils.NewAccessToken()
+	if err != nil {
+		http.Error(w, "トークンの生成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	prefix := token[:len(utils.AccessTokenPrefix)+6]
+
+	info := AccessTokenInfo{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		Scopes:    req.Scopes,
+		RoomID:    req.RoomID,
+		ExpiresAt: expiresAt,
+	}
+	err = s.DB.QueryRow(`
+		INSERT INTO personal_access_tokens
+			(user_id, name, token_hash, token_prefix, scopes, room_id, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`, userID, req.Name, utils.HashToken(token), prefix, pq.Array(req.Scopes), req.RoomID, createdBy, expiresAt,
+	).Scan(&info.ID, &info.CreatedAt)
+	if err != nil {
+		http.Error(w, "トークンの保存に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":   token, // 平文はこのレスポンスでのみ返す
+		"details": info,
+	})
+}
+
+func (s *Server) listAccessTokens(w http.ResponseWriter, userID int) {
+	rows, err := s.DB.Query(`
+		SELECT id, user_id, name, token_prefix, scopes, room_id,
+		       created_at, expires_at, last_used_at, revoked_at IS NOT NULL
+		FROM personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		http.Error(w, "トークンの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []AccessTokenInfo{}
+	for rows.Next() {
+		var t AccessTokenInfo
+		var scopes pq.StringArray
+		var roomID sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &roomID,
+			&t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt, &t.Revoked); err != nil {
+			continue
+		}
+		t.Scopes = scopes
+		if roomID.Valid {
+			id := int(roomID.Int64)
+			t.RoomID = &id
+		}
+		tokens = append(tokens, t)
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"tokens": tokens})
+}
@@ -1,20 +1,294 @@
 package handlers
 
 import (
+	"backend/media"
+	"backend/storage"
 	"backend/utils"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 )
 
+// 添付ファイル（message_attachments の1行分）
+type storedAttachment struct {
+	FileName        string
+	StorageKey      string
+	ContentType     string
+	Size            int64
+	Width           *int
+	Height          *int
+	ThumbnailKey    *string
+	ThumbnailWidth  *int
+	ThumbnailHeight *int
+
+	// ストレージにまだ書き込んでいない内容（同じ内容の blob がなければ登録時に書き込む）
+	spool         *os.File // 一時ファイル（画像はメタデータ除去済み）
+	thumbnail     []byte
+	thumbnailType string
+}
+
+// 一時ファイルを削除
+func (a *storedAttachment) close() {
+	if a.spool != nil {
+		a.spool.Close()
+		os.Remove(a.spool.Name())
+		a.spool = nil
+	}
+}
+
+// 保存する内容を先頭から読む Reader
+func (a *storedAttachment) open() (io.Reader, error) {
+	if a.spool == nil {
+		return nil, os.ErrClosed
+	}
+	if _, err := a.spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return a.spool, nil
+}
+
+func closeAttachments(atts []*storedAttachment) {
+	for _, a := range atts {
+		a.close()
+	}
+}
+
+// アップロードを受け付けられない理由（HTTP ステータス付き）
+type uploadError struct {
+	status int
+	msg    string
+}
+
+func (e *uploadError) Error() string { return e.msg }
+
+// ✅ アップロードされたファイルを検証して保存の準備をする
+// 形式は内容から判定し、画像はメタデータを除去してサムネイルを作成する
+func (s *Server) prepareAttachment(fh *multipart.FileHeader) (*storedAttachment, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "ファイルの読み込みに失敗しました"}
+	}
+	defer file.Close()
+	return s.prepareAttachmentFrom(fh.Filename, fh.Size, file)
+}
+
+// 先頭のバイト列から形式を判定し、許可された形式・サイズか確認する
+func (s *Server) checkAttachment(head []byte, size int64) (string, error) {
+	// 拡張子やクライアントの申告ではなく内容から形式を判定
+	contentType := utils.SniffContentType(head)
+	if !s.AttachmentPolicy.Allows(contentType) {
+		return "", &uploadError{http.StatusUnsupportedMediaType, fmt.Sprintf("この形式のファイルはアップロードできません（%s）", contentType)}
+	}
+	if limit := s.AttachmentPolicy.MaxSize(contentType); size > limit {
+		return "", &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("ファイルサイズが上限（%d MB）を超えています", limit>>20)}
+	}
+	return contentType, nil
+}
+
+// src（size バイト）を検証し、内容のハッシュから保存キーを決める
+// 書き込みは insertMessageWithAttachments で行う（使い終わったら close で一時ファイルを消す）
+func (s *Server) prepareAttachmentFrom(fileName string, size int64, src io.Reader) (*storedAttachment, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	contentType, err := s.checkAttachment(head, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// 元のファイル名は表示名として DB にだけ保存する
+	att := &storedAttachment{
+		FileName:    utils.SanitizeFileName(fileName),
+		ContentType: contentType,
+		Size:        size,
+	}
+
+	// 判定に使った先頭部分を戻し、ハッシュを計算しながら一時ファイルに書き出す
+	body := io.MultiReader(bytes.NewReader(head), src)
+	spool, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return nil, err
+	}
+	att.spool = spool
+	h := sha256.New()
+	written, err := io.Copy(io.MultiWriter(spool, h), body)
+	if err == nil && written != size {
+		err = &uploadError{http.StatusBadRequest, "ファイルサイズが一致しません"}
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err == nil && strings.HasPrefix(contentType, "image/") {
+		err = att.processImage()
+	}
+	if err != nil {
+		att.close()
+		return nil, err
+	}
+	if att.StorageKey == "" {
+		att.StorageKey = utils.ContentAttachmentKey(h.Sum(nil), contentType)
+	}
+	return att, nil
+}
+
+// 一時ファイルの画像からメタデータを除去し、寸法とサムネイルを記録する
+// 画素数はヘッダーで先に確認し、上限を超える画像はメモリに読み込まない
+func (a *storedAttachment) processImage() error {
+	if err := media.CheckImageSize(bufio.NewReader(a.spool), a.ContentType); err != nil {
+		if errors.Is(err, media.ErrImageTooLarge) {
+			return &uploadError{http.StatusRequestEntityTooLarge, "画像の解像度が大きすぎます"}
+		}
+		return &uploadError{http.StatusBadRequest, "画像を読み込めませんでした"}
+	}
+	if _, err := a.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(a.spool)
+	if err != nil {
+		return err
+	}
+	img, err := media.ProcessImage(data, a.ContentType)
+	if err != nil {
+		return &uploadError{http.StatusBadRequest, "画像を読み込めませんでした"}
+	}
+
+	// メタデータを除いた内容で一時ファイルを書き直す
+	if err := a.spool.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := a.spool.WriteAt(img.Data, 0); err != nil {
+		return err
+	}
+	if _, err := a.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(img.Data)
+	a.StorageKey = utils.ContentAttachmentKey(sum[:], a.ContentType)
+	a.Size = int64(len(img.Data))
+	if img.Width > 0 {
+		a.Width, a.Height = &img.Width, &img.Height
+	}
+	if img.Thumbnail != nil {
+		key := thumbnailKey(a.StorageKey, img.ThumbnailContentType)
+		a.ThumbnailKey = &key
+		a.ThumbnailWidth, a.ThumbnailHeight = &img.ThumbnailWidth, &img.ThumbnailHeight
+		a.thumbnail, a.thumbnailType = img.Thumbnail, img.ThumbnailContentType
+	}
+	return nil
+}
+
+// 添付ファイルのキーに対応するサムネイルのキー（形式に応じて拡張子だけ変わる）
+// 例: attachments/sha256/3f/3f2a...c1.webp → thumbnails/sha256/3f/3f2a...c1.jpg
+func thumbnailKey(storageKey, contentType string) string {
+	base := strings.TrimSuffix(strings.TrimPrefix(storageKey, "attachments/"), path.Ext(storageKey))
+	if contentType == "image/png" {
+		return "thumbnails/" + base + ".png"
+	}
+	return "thumbnails/" + base + ".jpg"
+}
+
+// *sql.DB と *sql.Tx の両方で使えるように
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func (a *storedAttachment) insert(db sqlExecer, messageID int, now time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO message_attachments (message_id, file_name, storage_key, content_type, size_bytes,
+			width, height, thumbnail_key, thumbnail_width, thumbnail_height, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, messageID, a.FileName, a.StorageKey, a.ContentType, a.Size,
+		a.Width, a.Height, a.ThumbnailKey, a.ThumbnailWidth, a.ThumbnailHeight, now)
+	return err
+}
+
+// 1メッセージに添付できるファイル数の上限
+const maxAttachmentsPerMessage = 10
+
+// クライアントに返す添付ファイルの情報（URL は署名付き）
+type MessageAttachment struct {
+	URL             string  `json:"url"`
+	Name            string  `json:"name"`
+	ContentType     string  `json:"content_type"`
+	Size            int64   `json:"size"`
+	Width           *int    `json:"width,omitempty"`
+	Height          *int    `json:"height,omitempty"`
+	Thumbnail       *string `json:"thumbnail,omitempty"`
+	ThumbnailWidth  *int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight *int    `json:"thumbnail_height,omitempty"`
+}
+
+func (a *storedAttachment) view() MessageAttachment {
+	v := MessageAttachment{
+		URL:             utils.SignFileURL(a.StorageKey),
+		Name:            a.FileName,
+		ContentType:     a.ContentType,
+		Size:            a.Size,
+		Width:           a.Width,
+		Height:          a.Height,
+		ThumbnailWidth:  a.ThumbnailWidth,
+		ThumbnailHeight: a.ThumbnailHeight,
+	}
+	if a.ThumbnailKey != nil {
+		url := utils.SignFileURL(*a.ThumbnailKey)
+		v.Thumbnail = &url
+	}
+	return v
+}
+
+// ✅ 複数メッセージの添付ファイルをまとめて取得（message_id → 添付ファイル一覧）
+func (s *Server) messageAttachments(messageIDs []int) (map[int][]MessageAttachment, error) {
+	result := make(map[int][]MessageAttachment)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+	rows, err := s.DB.Query(`
+		SELECT message_id, file_name, storage_key, content_type, size_bytes,
+			width, height, thumbnail_key, thumbnail_width, thumbnail_height
+		FROM message_attachments
+		WHERE message_id = ANY($1)
+		ORDER BY id ASC
+	`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var a storedAttachment
+		if err := rows.Scan(&messageID, &a.FileName, &a.StorageKey, &a.ContentType, &a.Size,
+			&a.Width, &a.Height, &a.ThumbnailKey, &a.ThumbnailWidth, &a.ThumbnailHeight); err != nil {
+			return nil, err
+		}
+		result[messageID] = append(result[messageID], a.view())
+	}
+	return result, rows.Err()
+}
+
 // POST /messages/upload
+// multipart で本文（content）と複数のファイル（file を繰り返し）を1つのメッセージとして投稿する
+// 任意: thread_root_id, mentions（繰り返し）
 func (s *Server) UploadMessageAttachmentHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := utils.GetUserIDFromToken(r)
 	if err != nil {
@@ -22,18 +296,23 @@ func (s *Server) UploadMessageAttachmentHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = r.ParseMultipartForm(10 << 20) // 最大 10MB
+	// 1リクエストの合計は形式ごとの上限のうち最大のものまで
+	r.Body = http.MaxBytesReader(w, r.Body, s.AttachmentPolicy.MaxUploadSize()+(1<<20))
+	err = r.ParseMultipartForm(10 << 20) // メモリ上は 10MB まで（超えた分は一時ファイル）
 	if err != nil {
-		http.Error(w, "ファイル形式エラー", http.StatusBadRequest) // 檔案格式錯誤
+		http.Error(w, "ファイル形式エラー、またはファイルサイズが大きすぎます", http.StatusBadRequest) // 檔案格式錯誤
 		return
 	}
 
-	file, handler, err := r.FormFile("file")
-	if err != nil {
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
 		http.Error(w, "ファイルが提供されていません", http.StatusBadRequest) // 未提供檔案
 		return
 	}
-	defer file.Close()
+	if len(files) > maxAttachmentsPerMessage {
+		http.Error(w, fmt.Sprintf("添付できるファイルは %d 件までです", maxAttachmentsPerMessage), http.StatusBadRequest)
+		return
+	}
 
 	roomIDStr := r.FormValue("room_id") // フォームフィールド（例：ファイルアップロード時）
 	roomID, err := strconv.Atoi(roomIDStr)
@@ -41,84 +320,302 @@ func (s *Server) UploadMessageAttachmentHandler(w http.ResponseWriter, r *http.R
 		http.Error(w, "無効な room_id", http.StatusBadRequest) // 無效的 room_id
 		return
 	}
+	var threadRootID *int
+	if v := r.FormValue("thread_root_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "無効な thread_root_id", http.StatusBadRequest)
+			return
+		}
+		threadRootID = &id
+	}
+	content := r.FormValue("content")
+	mentions := r.MultipartForm.Value["mentions"]
 
-	uploadDir := "public/uploads"
-	fileName := fmt.Sprintf("%d_%s", time.Now().UnixNano(), handler.Filename)
-	fullPath := filepath.Join(uploadDir, fileName)
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermPostMessage); !ok {
+		return
+	}
 
-	out, err := os.Create(fullPath)
-	if err != nil {
-		http.Error(w, "ファイル保存に失敗しました", http.StatusInternalServerError) // 無法儲存檔案
+	// ✅ 保存容量の上限を確認
+	var incoming int64
+	for _, fh := range files {
+		incoming += fh.Size
+	}
+	if err := s.checkStorageQuota(userID, roomID, incoming); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	// ✅ すべてのファイルを検証してからメッセージを登録する
+	var atts []*storedAttachment
+	defer func() { closeAttachments(atts) }()
+	for _, fh := range files {
+		att, err := s.prepareAttachment(fh)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		atts = append(atts, att)
+	}
+
+	// ✅ 公開前にマルウェア検査（検出されたら全体を拒否）
+	rejected, err := s.scanAttachments(r.Context(), userID, roomID, atts)
+	if !writeScanResult(w, rejected, err) {
 		return
 	}
-	defer out.Close()
-	io.Copy(out, file)
 
 	now := time.Now()
-	var messageID int
-	err = s.DB.QueryRow(`
-		INSERT INTO messages (room_id, sender_id, content, created_at, updated_at)
-		VALUES ($1, $2, '', $3, $4) RETURNING id
-	`, roomID, userID, now, now).Scan(&messageID)
-	if err != nil {
+	messageID, err := s.insertMessageWithAttachments(r.Context(), roomID, userID, content, threadRootID, atts, now)
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		writeUploadError(w, err)
+		return
+	} else if err != nil {
+		log.Println("❌ 添付メッセージの書き込みに失敗:", err)
 		http.Error(w, "メッセージ書き込みに失敗しました", http.StatusInternalServerError) // 寫入訊息失敗
 		return
 	}
 
-	_, err = s.DB.Exec(`
-		INSERT INTO message_attachments (message_id, file_name, created_at)
-		VALUES ($1, $2, $3)
-	`, messageID, fileName, now)
-	if err != nil {
-		http.Error(w, "添付ファイルの保存に失敗しました", http.StatusInternalServerError) // 寫入附件失敗
-		return
+	attachments := s.publishAttachmentMessage(roomID, userID, messageID, content, threadRootID, mentions, atts, now)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":     "アップロード成功", // 上傳成功
+		"id":          messageID,
+		"attachments": attachments,
+		"file_path":   attachments[0].URL,
+	})
+}
+
+// ✅ 添付メッセージの登録後の処理（既読・メンション・ブロードキャスト）
+func (s *Server) publishAttachmentMessage(roomID, userID, messageID int, content string, threadRootID *int, mentions []string, atts []*storedAttachment, now time.Time) []MessageAttachment {
+	// ✅ 自分の投稿までは既読扱い
+	_ = s.advanceReadCursor(roomID, userID, messageID)
+
+	// ✅ メンション保存
+	if len(mentions) > 0 {
+		s.SaveMentionsAndNotify(messageID, mentions)
 	}
 
 	// 送信者名を取得
 	var sender string
 	_ = s.DB.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&sender)
 
+	attachments := make([]MessageAttachment, len(atts))
+	for i, a := range atts {
+		attachments[i] = a.view()
+	}
+
 	// WebSocket 経由で新メッセージをブロードキャスト
+	// attachment / attachment_name は旧クライアント向けに先頭のファイルを示す
 	s.WSHub.Broadcast <- WSMessage{
 		RoomID: roomID,
 		Data: map[string]any{
 			"type": "new_message",
 			"message": map[string]any{
-				"id":         messageID,
-				"room_id":    roomID,
-				"sender":     sender,
-				"content":    "", // 空のメッセージ
-				"attachment": "/uploads/" + fileName,
-				"created_at": now.Format(time.RFC3339),
+				"id":              messageID,
+				"room_id":         roomID,
+				"sender":          sender,
+				"content":         content,
+				"created_at":      now.Format(time.RFC3339),
+				"thread_root_id":  threadRootID,
+				"attachments":     attachments,
+				"attachment":      attachments[0].URL,
+				"attachment_name": attachments[0].Name,
 			},
 		},
 	}
+	s.broadcastUnread(roomID)
+	return attachments
+}
+
+// メッセージと添付ファイルを1トランザクションで登録
+// 内容が同じ blob が既にあれば参照数を増やすだけで、ストレージには書き込まない
+// 保存容量の上限を超える場合は *uploadError を返す
+func (s *Server) insertMessageWithAttachments(ctx context.Context, roomID, userID int, content string, threadRootID *int, atts []*storedAttachment, now time.Time) (messageID int, err error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var written []string
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			s.deleteUnreferencedObjects(ctx, written)
+		}
+	}()
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message":   "アップロード成功", // 上傳成功
-		"file_path": "/uploads/" + fileName,
-	})
+	// ✅ 保存容量の上限をロックした状態で確認
+	var incoming int64
+	for _, a := range atts {
+		incoming += a.Size
+	}
+	if err = s.lockStorageQuota(tx, userID, roomID, incoming); err != nil {
+		return 0, err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO messages (room_id, sender_id, content, created_at, updated_at, thread_root_id)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+	`, roomID, userID, content, now, now, threadRootID).Scan(&messageID)
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range atts {
+		keys, err := s.acquireBlob(ctx, tx, a)
+		written = append(written, keys...)
+		if err != nil {
+			return 0, err
+		}
+		if err := a.insert(tx, messageID, now); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return messageID, nil
 }
 
-// GET /downloads/{filename}
+// prepareAttachment のエラーをレスポンスに変換
+func writeUploadError(w http.ResponseWriter, err error) {
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		http.Error(w, ue.msg, ue.status)
+		return
+	}
+	log.Println("❌ ファイル保存に失敗:", err)
+	http.Error(w, "ファイル保存に失敗しました", http.StatusInternalServerError) // 無法儲存檔案
+}
+
+// GET /downloads/{key} 添付ファイルをダウンロード（ルームのメンバーのみ）
 func (s *Server) DownloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
-	filename := mux.Vars(r)["filename"]
-	if filename == "" {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+	key := mux.Vars(r)["key"]
+	if key == "" {
 		http.Error(w, "ファイル名が無効です", http.StatusBadRequest)
 		return
 	}
 
-	filePath := filepath.Join("public", "uploads", filename)
+	// 同じ内容のファイルは複数のルームで共有されるため、自分が参加しているルームの添付を優先する
+	var roomID int
+	var fileName, contentType string
+	err = s.DB.QueryRow(`
+		SELECT m.room_id, a.file_name, a.content_type FROM message_attachments a
+		JOIN messages m ON m.id = a.message_id
+		WHERE a.storage_key = $1
+		ORDER BY EXISTS (
+			SELECT 1 FROM room_members rm WHERE rm.room_id = m.room_id AND rm.user_id = $2
+		) DESC, a.id DESC
+		LIMIT 1
+	`, key, userID).Scan(&roomID, &fileName, &contentType)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
+
+	// 内容アドレスの blob は内容が変わらないので長くキャッシュさせる（それ以外は毎回再検証）
+	var maxAge time.Duration
+	if _, immutable := utils.ContentETag(key); immutable {
+		maxAge = immutableFileMaxAge
+	}
+	s.serveStorageObject(w, r, key, fileName, contentType, true, maxAge)
+}
+
+// 変更されないファイルのキャッシュ期間
+const immutableFileMaxAge = 365 * 24 * time.Hour
+
+// GET /files/{exp}/{sig}/{key} 署名付き URL でファイルを返す（画像表示用、?download=1 で保存）
+// URL はメンバーにだけ渡されるため、ここでは署名と期限のみ確認する
+func (s *Server) SignedFileHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+	expiresAt, ok := utils.VerifyFileURL(key, vars["exp"], vars["sig"])
+	if !ok {
+		http.Error(w, "URL の有効期限が切れているか、署名が無効です", http.StatusForbidden)
+		return
+	}
 
-	file, err := os.Open(filePath)
+	// 添付ファイルなら元のファイル名と判定済みの形式を使う（アイコンなどはストレージの情報）
+	var fileName, contentType string
+	err := s.DB.QueryRow(`
+		SELECT file_name, content_type FROM message_attachments WHERE storage_key = $1 LIMIT 1
+	`, key).Scan(&fileName, &contentType)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+
+	s.serveStorageObject(w, r, key, fileName, contentType, r.URL.Query().Get("download") == "1", time.Until(expiresAt))
+}
+
+// ストレージ上のファイルを返す（Range / If-Range / If-None-Match / If-Modified-Since に対応）
+// 表示してよい形式以外は常にダウンロード扱いにし、ブラウザに形式を推測させない
+// maxAge はブラウザにキャッシュさせてよい期間（0 なら毎回 ETag で再検証）
+func (s *Server) serveStorageObject(w http.ResponseWriter, r *http.Request, key, fileName, contentType string, download bool, maxAge time.Duration) {
+	file, info, err := storage.Open(r.Context(), s.Storage, key)
 	if err != nil {
-		http.NotFound(w, r)
+		storageError(w, r, err)
 		return
 	}
 	defer file.Close()
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	io.Copy(w, file)
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if fileName == "" {
+		fileName = path.Base(key)
+	}
+	disposition := "inline"
+	if download || !utils.InlineSafe(contentType) {
+		disposition = "attachment"
+	}
+
+	// 内容アドレスの blob は変更されないので immutable としてキャッシュさせる
+	// それ以外（旧形式のキーやアイコン）はサイズと更新日時から弱い ETag を作る
+	etag, immutable := utils.ContentETag(key)
+	if !immutable && !info.ModTime.IsZero() {
+		etag = fmt.Sprintf(`W/"%x-%x"`, info.Size, info.ModTime.UnixNano())
+	}
+	switch {
+	case maxAge <= 0:
+		w.Header().Set("Cache-Control", "private, no-cache")
+	case immutable:
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", int(maxAge.Seconds())))
+	default:
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", utils.ContentDisposition(disposition, fileName))
+	// 範囲指定・条件付きリクエスト（206 / 304 / 412 / 416）は ServeContent が処理する
+	http.ServeContent(w, r, "", info.ModTime, file)
+}
+
+// ストレージのエラーを HTTP ステータスに変換
+func storageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+		http.NotFound(w, r)
+	default:
+		log.Println("❌ ストレージの読み込みに失敗:", err)
+		http.Error(w, "ファイルの取得に失敗しました", http.StatusInternalServerError)
+	}
 }
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"backend/storage"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// 添付ファイルの blob（attachment_blobs）の参照数管理
+//
+// 同じ内容のファイルは同じ storage_key になり、message_attachments の複数の行から共有される。
+// ストレージへの書き込みは attachment_blobs の行とキーのアドバイザリロックを持ったまま行い、
+// 削除は行の削除がコミットされた後に同じロックを取って参照がないことを確かめてから行う。
+// これにより削除中の blob を別のアップロードが再利用してしまうことはない。
+
+// ✅ blob の参照を1つ増やす。新しい blob ならストレージに書き込み、書き込んだキーを返す
+func (s *Server) acquireBlob(ctx context.Context, tx *sql.Tx, a *storedAttachment) ([]string, error) {
+	var refs int
+	err := tx.QueryRow(`
+		INSERT INTO attachment_blobs (storage_key, content_type, size_bytes, thumbnail_key, ref_count)
+		VALUES ($1, $2, $3, $4, 1)
+		ON CONFLICT (storage_key) DO UPDATE SET ref_count = attachment_blobs.ref_count + 1
+		RETURNING ref_count
+	`, a.StorageKey, a.ContentType, a.Size, a.ThumbnailKey).Scan(&refs)
+	if err != nil {
+		return nil, err
+	}
+	if refs > 1 {
+		// 既存の blob を共有（重複排除）
+		return nil, nil
+	}
+
+	// 同じキーのファイルを削除中なら終わるまで待つ
+	keys := []string{a.StorageKey}
+	if a.ThumbnailKey != nil {
+		keys = append(keys, *a.ThumbnailKey)
+	}
+	for _, key := range keys {
+		if err := lockObjectKey(tx, key); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := a.open()
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+	if err := s.Storage.Put(ctx, a.StorageKey, body, a.Size, a.ContentType); err != nil {
+		return written, err
+	}
+	written = append(written, a.StorageKey)
+	if a.ThumbnailKey != nil && a.thumbnail != nil {
+		if err := s.Storage.Put(ctx, *a.ThumbnailKey, bytes.NewReader(a.thumbnail), int64(len(a.thumbnail)), a.thumbnailType); err != nil {
+			return written, err
+		}
+		written = append(written, *a.ThumbnailKey)
+	}
+	return written, nil
+}
+
+// ストレージのキーごとのロック（トランザクション終了まで保持）
+func lockObjectKey(tx *sql.Tx, key string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, key)
+	return err
+}
+
+// どの blob からも参照されていないオブジェクトを消す
+// （登録に失敗したときに書き込んだもの、削除がコミットされた blob のもの）
+func (s *Server) deleteUnreferencedObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if _, err := s.deleteObjectIfUnreferenced(ctx, key); err != nil {
+			log.Println("⚠️ ファイル削除に失敗:", key, err)
+		}
+	}
+}
+
+// キーのロックを取り、どの blob からも参照されていなければ削除する（削除したら true）
+func (s *Server) deleteObjectIfUnreferenced(ctx context.Context, key string) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := lockObjectKey(tx, key); err != nil {
+		return false, err
+	}
+	var referenced bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM attachment_blobs WHERE storage_key = $1 OR thumbnail_key = $1)
+	`, key).Scan(&referenced)
+	if err != nil || referenced {
+		return false, err
+	}
+	if err := s.Storage.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *Server) deleteObject(ctx context.Context, key string) {
+	if err := s.Storage.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Println("⚠️ ファイル削除に失敗:", key, err)
+	}
+}
+
+// ✅ メッセージを削除し、最後の参照だった blob をストレージから消す
+func (s *Server) deleteMessage(ctx context.Context, messageID int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT storage_key FROM message_attachments WHERE message_id = $1`, messageID)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = $1`, messageID); err != nil {
+		return err
+	}
+
+	// ストレージの削除はコミット後に行う（ロールバックされた場合にファイルだけ消えないように）
+	var unreferenced []string
+	for _, key := range keys {
+		var refs int
+		var thumbnailKey sql.NullString
+		err := tx.QueryRow(`
+			UPDATE attachment_blobs SET ref_count = ref_count - 1
+			WHERE storage_key = $1
+			RETURNING ref_count, thumbnail_key
+		`, key).Scan(&refs, &thumbnailKey)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if refs > 0 {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM attachment_blobs WHERE storage_key = $1`, key); err != nil {
+			return err
+		}
+		unreferenced = append(unreferenced, key)
+		if thumbnailKey.Valid {
+			unreferenced = append(unreferenced, thumbnailKey.String)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// コミット後に同じ内容が再アップロードされていれば残す（失敗しても孤立ファイルとして残るだけ）
+	s.deleteUnreferencedObjects(ctx, unreferenced)
+	return nil
+}
+
+// ✅ どのメッセージからも参照されていない blob を定期的に削除する
+// （ルーム削除などで message_attachments が連鎖削除された場合や、参照数がずれた場合の後始末）
+// blob として登録されていない従来形式のファイルも、ストレージを一覧できれば合わせて削除する
+func (s *Server) RunAttachmentGC(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		if n := s.collectOrphanedBlobs(ctx) + s.collectLegacyObjects(ctx); n > 0 {
+			log.Printf("🧹 参照されていない添付ファイルを %d 件削除しました", n)
+		}
+	}
+}
+
+// 作成直後の blob は登録処理中の可能性があるので猶予を置く
+const orphanedBlobGracePeriod = time.Hour
+
+func (s *Server) collectOrphanedBlobs(ctx context.Context) int {
+	rows, err := s.DB.Query(`
+		SELECT b.storage_key FROM attachment_blobs b
+		WHERE b.created_at < $1
+		AND NOT EXISTS (SELECT 1 FROM message_attachments a WHERE a.storage_key = b.storage_key)
+		LIMIT 500
+	`, time.Now().Add(-orphanedBlobGracePeriod))
+	if err != nil {
+		log.Println("⚠️ 孤立した添付ファイルの取得に失敗:", err)
+		return 0
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+
+	deleted := 0
+	for _, key := range keys {
+		ok, err := s.deleteOrphanedBlob(ctx, key)
+		if err != nil {
+			log.Println("⚠️ 孤立した添付ファイルの削除に失敗:", key, err)
+			continue
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// 行をロックして参照がないことを確かめてから削除する（ファイルはコミット後に消す）
+func (s *Server) deleteOrphanedBlob(ctx context.Context, key string) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var thumbnailKey sql.NullString
+	err = tx.QueryRow(`
+		SELECT thumbnail_key FROM attachment_blobs WHERE storage_key = $1 FOR UPDATE
+	`, key).Scan(&thumbnailKey)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var referenced bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM message_attachments WHERE storage_key = $1)
+	`, key).Scan(&referenced)
+	if err != nil || referenced {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM attachment_blobs WHERE storage_key = $1`, key); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	objects := []string{key}
+	if thumbnailKey.Valid {
+		objects = append(objects, thumbnailKey.String)
+	}
+	s.deleteUnreferencedObjects(ctx, objects)
+	return true, nil
+}
+
+// 重複排除より前の保存キー（attachment_blobs の行を持たない）
+//
+//	"<UnixNano>_<元のファイル名>"       … 最初の実装（public/uploads 直下）
+//	"attachments/YYYY/MM/<ランダム>"   … ランダムキー
+//	"thumbnails/YYYY/MM/<ランダム>"    … そのサムネイル
+//
+// これらは blob として登録される前にメッセージが削除されていると、どこからも参照されずに残る
+var legacyObjectKey = regexp.MustCompile(`^([0-9]+_[^/]+|(attachments|thumbnails)/[0-9]{4}/[0-9]{2}/[^/]+)$`)
+
+const legacyObjectBatch = 500
+
+// ✅ どの添付ファイルからも参照されていない従来形式のファイルを削除する（一覧を取得できるストレージのみ）
+func (s *Server) collectLegacyObjects(ctx context.Context) int {
+	lister, ok := s.Storage.(storage.Lister)
+	if !ok {
+		return 0
+	}
+
+	// 一覧を少しずつ DB と突き合わせ、参照されていないものを最大 legacyObjectBatch 件集める
+	cutoff := time.Now().Add(-orphanedBlobGracePeriod)
+	var candidates, orphaned []string
+	flush := func() error {
+		keys, err := s.unreferencedAttachmentKeys(candidates)
+		candidates = candidates[:0]
+		orphaned = append(orphaned, keys...)
+		return err
+	}
+	errBatchFull := errors.New("batch full")
+	err := lister.List(ctx, "", func(obj storage.ObjectInfo) error {
+		if !legacyObjectKey.MatchString(obj.Key) || obj.ModTime.After(cutoff) {
+			return nil
+		}
+		if candidates = append(candidates, obj.Key); len(candidates) < legacyObjectBatch {
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if len(orphaned) >= legacyObjectBatch {
+			return errBatchFull
+		}
+		return nil
+	})
+	if err == nil && len(candidates) > 0 {
+		err = flush()
+	}
+	if err != nil && err != errBatchFull {
+		log.Println("⚠️ 孤立した添付ファイルの取得に失敗:", err)
+		return 0
+	}
+
+	// attachment_blobs の確認と削除はキーのロックを取って行う
+	deleted := 0
+	for _, key := range orphaned {
+		ok, err := s.deleteObjectIfUnreferenced(ctx, key)
+		if err != nil {
+			log.Println("⚠️ 孤立した添付ファイルの削除に失敗:", key, err)
+			continue
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// keys のうち message_attachments から参照されていないもの
+func (s *Server) unreferencedAttachmentKeys(keys []string) ([]string, error) {
+	rows, err := s.DB.Query(`
+		SELECT k FROM unnest($1::text[]) AS k
+		EXCEPT SELECT storage_key FROM message_attachments WHERE storage_key = ANY($1)
+		EXCEPT SELECT thumbnail_key FROM message_attachments WHERE thumbnail_key = ANY($1)
+	`, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		unreferenced = append(unreferenced, key)
+	}
+	return unreferenced, rows.Err()
+}
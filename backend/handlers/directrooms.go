@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// グループ DM の最大人数（自分を含む）
+const maxGroupDMMembers = 10
+
+type CreateGroupDMRequest struct {
+	UserIDs []int `json:"user_ids"` // 自分以外の参加者
+}
+
+// 参加者の組み合わせから DM のキーを作る（順番や重複に関係なく同じ値になる）
+func directRoomKey(userIDs []int) string {
+	ids := append([]int(nil), userIDs...)
+	sort.Ints(ids)
+	parts := make([]string, 0, len(ids))
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1] {
+			continue
+		}
+		parts = append(parts, strconv.Itoa(id))
+	}
+	return strings.Join(parts, ",")
+}
+
+// 参加者の組み合わせが完全に一致する DM を取得し、なければ作成する
+// 2人なら 1対1、3人以上ならグループ DM（名前なし・全員 member）
+// callerID は開いたユーザー（userIDs に含まれる）
+func (s *Server) findOrCreateDirectRoom(callerID int, userIDs []int) (roomID int, created bool, err error) {
+	key := directRoomKey(userIDs)
+	isGroup := strings.Count(key, ",") >= 2
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	// ✅ 同時に作成された場合でも一意インデックスにより1部屋になる
+	err = tx.QueryRow(`
+		INSERT INTO chat_rooms (room_name, is_group, dm_key)
+		VALUES ('', $1, $2)
+		ON CONFLICT (dm_key) WHERE dm_key IS NOT NULL DO NOTHING
+		RETURNING id
+	`, isGroup, key).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`SELECT id FROM chat_rooms WHERE dm_key = $1`, key).Scan(&roomID)
+	} else if err == nil {
+		created = true
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	// 作成時は全員を追加し、既存の DM では開いた本人だけを戻す（退出した他のメンバーは戻さない）
+	members := userIDs
+	if !created {
+		members = []int{callerID}
+	}
+	for _, uid := range members {
+		if _, err := tx.Exec(`
+			INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, roomID, uid, RoleMember); err != nil {
+			return 0, false, err
+		}
+	}
+	return roomID, created, tx.Commit()
+}
+
+// POST /group-dm 参加者の組み合わせでグループ DM を取得または作成
+func (s *Server) GetOrCreateGroupDMHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+
+	var req CreateGroupDMRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "リクエストボディの形式が無効です", http.StatusBadRequest)
+		return
+	}
+
+	// 自分を含めた参加者（重複は除く）
+	seen := map[int]bool{userID: true}
+	participants := []int{userID}
+	for _, id := range req.UserIDs {
+		if !seen[id] {
+			seen[id] = true
+			participants = append(participants, id)
+		}
+	}
+	if len(participants) < 3 {
+		http.Error(w, "グループ DM には自分以外に2人以上の参加者が必要です", http.StatusBadRequest)
+		return
+	}
+	if len(participants) > maxGroupDMMembers {
+		http.Error(w, fmt.Sprintf("グループ DM の参加者は %d 人までです", maxGroupDMMembers), http.StatusBadRequest)
+		return
+	}
+
+	var found int
+	if err := s.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ANY($1)`, pq.Array(participants)).Scan(&found); err != nil {
+		http.Error(w, "ユーザーの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if found != len(participants) {
+		http.Error(w, "存在しないユーザーが含まれています", http.StatusBadRequest)
+		return
+	}
+
+	roomID, created, err := s.findOrCreateDirectRoom(userID, participants)
+	if err != nil {
+		http.Error(w, "ルームの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		// 参加者本人の接続にだけ新しいルームを通知（誰と DM しているかは参加者以外に見せない）
+		data := map[string]any{
+			"type":    "room_created",
+			"room_id": roomID,
+			"members": participants,
+			"by":      userID,
+		}
+		for _, id := range participants {
+			s.WSHub.SendToUser <- UserMessage{UserID: id, Data: data}
+		}
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"room_id": roomID,
+		"created": created,
+		"members": participants,
+	})
+}
@@ -5,53 +5,186 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"backend/utils"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 )
 
 type RoomInfo struct {
-	ID       int    `json:"id"`
-	RoomName string `json:"room_name"`
-	IsGroup  bool   `json:"is_group"`
+	ID          int    `json:"id"`
+	RoomName    string `json:"room_name"`
+	IsGroup     bool   `json:"is_group"`
+	IsPublic    bool   `json:"is_public"`
+	Topic       string `json:"topic"`
+	Description string `json:"description"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	// false の場合、このルームでは既読者を表示しない
+	ReadReceiptsEnabled bool `json:"read_receipts_enabled"`
+	// 参加者の組み合わせで識別される DM（名前なし）
+	IsDirect bool `json:"is_direct"`
+}
+
+// RoomInfo を取得するための共通カラム（chat_rooms の別名は cr）
+const roomInfoColumns = `cr.id, cr.room_name, cr.is_group, cr.is_public, cr.topic, cr.description, cr.avatar_file, cr.read_receipts_enabled, cr.dm_key IS NOT NULL`
+
+// roomInfoColumns の結果を RoomInfo に読み込む（続くカラムは extra に読み込む）
+func scanRoomInfo(row interface{ Scan(...any) error }, room *RoomInfo, extra ...any) error {
+	var avatar sql.NullString
+	dest := append([]any{&room.ID, &room.RoomName, &room.IsGroup, &room.IsPublic,
+		&room.Topic, &room.Description, &avatar, &room.ReadReceiptsEnabled, &room.IsDirect}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	if avatar.Valid {
+		room.AvatarURL = utils.SignFileURL(avatar.String)
+	}
+	return nil
 }
 
 type RoomMembersResponse struct {
 	Members []string `json:"members"`
 }
 
+// ルーム一覧の最新メッセージ（プレビュー用）
+type RoomLastMessage struct {
+	ID            int       `json:"id"`
+	SenderID      int       `json:"sender_id"`
+	Sender        string    `json:"sender"`
+	Preview       string    `json:"preview"`
+	HasAttachment bool      `json:"has_attachment"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// GET /rooms の各要素（サイドバー表示に必要な情報をまとめて返す）
+type RoomListItem struct {
+	RoomInfo
+	LastMessage    *RoomLastMessage `json:"last_message"`
+	LastActivityAt time.Time        `json:"last_activity_at"`
+	UnreadCount    int              `json:"unread_count"`
+	MarkedUnread   bool             `json:"marked_unread"` // 手動で未読にしたルーム
+	MentionCount   int              `json:"mention_count"`
+	MemberCount    int              `json:"member_count"`
+	// DM の場合は自分以外の参加者名（表示名の代わり）
+	Participants []string `json:"participants,omitempty"`
+	RoomPreferences
+}
+
+// プレビューの最大文字数
+const roomPreviewLength = 100
+
 // GET /rooms ユーザーが参加しているすべてのチャットルームを取得
+// 最新メッセージ・未読数・メンション数・メンバー数を1クエリで取得し、ピン留め → 最終アクティビティ順に返す
+// アーカイブ中のルームは ?archived=true の場合のみ含める
 func (s *Server) GetUserRoomsHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := utils.GetUserIDFromToken(r)
 	if err != nil {
 		http.Error(w, "トークンが無効です", http.StatusUnauthorized) // token無効
 		return
 	}
+	includeArchived := r.URL.Query().Get("archived") == "true"
 
 	rows, err := s.DB.Query(`
-		SELECT cr.id, cr.room_name, cr.is_group
+		SELECT `+roomInfoColumns+`,
+			lm.id, lm.sender_id, lm.username, lm.content, lm.has_attachment, lm.created_at,
+			COALESCE(lm.created_at, cr.created_at) AS last_activity_at,
+			(
+				SELECT COUNT(*) FROM messages m
+				WHERE m.room_id = cr.id
+				  AND m.sender_id <> $1
+				  AND NOT m.content LIKE 'reaction:%'
+				  AND m.id > COALESCE(rm.last_read_message_id, 0)
+			) AS unread_count,
+			(
+				SELECT COUNT(*) FROM mentions me
+				JOIN messages m ON m.id = me.message_id
+				WHERE m.room_id = cr.id
+				  AND me.mention_target_id = $1
+				  AND m.id > COALESCE(rm.last_read_message_id, 0)
+			) AS mention_count,
+			(SELECT COUNT(*) FROM room_members x WHERE x.room_id = cr.id) AS member_count,
+			rm.marked_unread,
+			`+roomMutedExpr+`, rm.muted_until, rm.pinned_at IS NOT NULL, `+roomArchivedExpr+`,
+			CASE WHEN cr.dm_key IS NOT NULL THEN ARRAY(
+				SELECT u.username FROM room_members x
+				JOIN users u ON u.id = x.user_id
+				WHERE x.room_id = cr.id AND x.user_id <> $1
+				ORDER BY u.username
+			) END AS participants
 		FROM chat_rooms cr
-		JOIN room_members rm ON cr.id = rm.room_id
-		WHERE rm.user_id = $1
-	`, userID)
+		JOIN room_members rm ON cr.id = rm.room_id AND rm.user_id = $1
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.sender_id, u.username, m.content, m.created_at,
+				EXISTS (SELECT 1 FROM message_attachments a WHERE a.message_id = m.id) AS has_attachment
+			FROM messages m
+			JOIN users u ON u.id = m.sender_id
+			WHERE m.room_id = cr.id
+			  AND NOT m.content LIKE 'reaction:%'
+			  AND NOT EXISTS (
+				SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id = $1
+			  )
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT 1
+		) lm ON true
+		WHERE $2 OR NOT `+roomArchivedExpr+`
+		ORDER BY rm.pinned_at ASC NULLS LAST, last_activity_at DESC, cr.id DESC
+	`, userID, includeArchived)
 	if err != nil {
 		http.Error(w, "ルームの取得に失敗しました", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var rooms []RoomInfo
+	rooms := []RoomListItem{}
 	for rows.Next() {
-		var room RoomInfo
-		if err := rows.Scan(&room.ID, &room.RoomName, &room.IsGroup); err == nil {
-			rooms = append(rooms, room)
+		var item RoomListItem
+		var lastID, lastSenderID sql.NullInt64
+		var lastSender, lastContent sql.NullString
+		var lastHasAttachment sql.NullBool
+		var lastCreatedAt, mutedUntil sql.NullTime
+		err := scanRoomInfo(rows, &item.RoomInfo,
+			&lastID, &lastSenderID, &lastSender, &lastContent, &lastHasAttachment, &lastCreatedAt,
+			&item.LastActivityAt, &item.UnreadCount, &item.MentionCount, &item.MemberCount, &item.MarkedUnread,
+			&item.Muted, &mutedUntil, &item.Pinned, &item.Archived,
+			(*pq.StringArray)(&item.Participants),
+		)
+		if err != nil {
+			continue
 		}
+		if item.Muted && mutedUntil.Valid {
+			item.MutedUntil = &mutedUntil.Time
+		}
+		if lastID.Valid {
+			item.LastMessage = &RoomLastMessage{
+				ID:            int(lastID.Int64),
+				SenderID:      int(lastSenderID.Int64),
+				Sender:        lastSender.String,
+				Preview:       messagePreview(lastContent.String, lastHasAttachment.Bool),
+				HasAttachment: lastHasAttachment.Bool,
+				CreatedAt:     lastCreatedAt.Time,
+			}
+		}
+		rooms = append(rooms, item)
 	}
 
 	json.NewEncoder(w).Encode(rooms)
 }
 
+// メッセージ本文を一覧表示用に短くする（添付のみの場合は代替テキスト）
+func messagePreview(content string, hasAttachment bool) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if content == "" && hasAttachment {
+		return "[ファイル]"
+	}
+	if runes := []rune(content); len(runes) > roomPreviewLength {
+		return string(runes[:roomPreviewLength]) + "…"
+	}
+	return content
+}
+
 // POST /create-group-room グループチャットルームを作成
 func (s *Server) CreateGroupRoomHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := utils.GetUserIDFromToken(r)
@@ -61,34 +194,53 @@ func (s *Server) CreateGroupRoomHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	var payload struct {
-		RoomName string `json:"room_name"`
-		UserIDs  []int  `json:"user_ids"`
+		RoomName    string `json:"room_name"`
+		UserIDs     []int  `json:"user_ids"`
+		IsPublic    bool   `json:"is_public"` // true なら招待なしで参加可能
+		Topic       string `json:"topic"`
+		Description string `json:"description"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "データの解析に失敗しました", http.StatusBadRequest) // データの解析が失敗しました
 		return
 	}
 
-	var roomID int
-	err = s.DB.QueryRow(`SELECT id FROM chat_rooms WHERE room_name = $1 AND is_group = true`, payload.RoomName).Scan(&roomID)
-	if err == sql.ErrNoRows {
-		err = s.DB.QueryRow(
-			`INSERT INTO chat_rooms (room_name, is_group) VALUES ($1, true) RETURNING id`,
-			payload.RoomName,
-		).Scan(&roomID)
-		if err != nil {
-			http.Error(w, "グループルームの作成に失敗しました", http.StatusInternalServerError)
-			return
-		}
-	} else if err != nil {
-		http.Error(w, "ルームデータの取得に失敗しました", http.StatusInternalServerError)
+	payload.RoomName = strings.TrimSpace(payload.RoomName)
+	if msg := validateRoomSettings(payload.RoomName, payload.Topic, payload.Description); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 
-	memberSet := append(payload.UserIDs, userID)
-	for _, uid := range memberSet {
-		_, _ = s.DB.Exec(`INSERT INTO room_members (room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, roomID, uid) // すでに存在する場合は何もしない
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "グループルームの作成に失敗しました", http.StatusInternalServerError)
+		return
 	}
+	defer tx.Rollback()
+
+	// ✅ ルームは ID で識別する（同名のグループがあっても常に新規作成）
+	var roomID int
+	err = tx.QueryRow(
+		`INSERT INTO chat_rooms (room_name, is_group, is_public, topic, description)
+		 VALUES ($1, true, $2, $3, $4) RETURNING id`,
+		payload.RoomName, payload.IsPublic, payload.Topic, payload.Description,
+	).Scan(&roomID)
+	if err != nil {
+		http.Error(w, "グループルームの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	// ✅ 作成者がオーナーになる（オーナーのいないルームが残らないよう同じトランザクションで）
+	_, err = tx.Exec(`INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, 'owner')`, roomID, userID)
+	if err != nil {
+		http.Error(w, "ルームメンバーの追加に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "グループルームの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	s.addRoomMembers(roomID, payload.UserIDs, RoleMember)
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"room_id": roomID,
@@ -113,7 +265,8 @@ func (s *Server) JoinGroupRoomHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.DB.QueryRow("SELECT id FROM chat_rooms WHERE id = $1 AND is_group = true", roomID).Scan(&roomID)
+	var isPublic bool
+	err = s.DB.QueryRow("SELECT is_public FROM chat_rooms WHERE id = $1 AND is_group = true", roomID).Scan(&isPublic)
 	if err == sql.ErrNoRows {
 		http.Error(w, "ルームが存在していません", http.StatusNotFound)
 		return
@@ -122,16 +275,6 @@ func (s *Server) JoinGroupRoomHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// var exists int
-	// /// 実際のデータではなく、存在チェックをするだけ
-	// s.DB.QueryRow("SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2", roomID, userID).Scan(&exists)
-	// if exists != 1 {
-	// 	_, err := s.DB.Exec("INSERT INTO room_members (room_id, user_id) VALUES ($1, $2)", roomID, userID)
-	// 	if err != nil {
-	// 		http.Error(w, "参加に失敗しました", http.StatusInternalServerError)
-	// 		return
-	// 	}
-	// }
 	var exists bool
 	err = s.DB.QueryRow(`
 		SELECT EXISTS (
@@ -145,28 +288,70 @@ func (s *Server) JoinGroupRoomHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !exists {
-		_, err := s.DB.Exec("INSERT INTO room_members (room_id, user_id) VALUES ($1, $2)", roomID, userID)
-		if err != nil {
-			http.Error(w, "参加に失敗しました", http.StatusInternalServerError)
+		// 公開グループ以外は招待リンク（POST /invites/{code}/join）からのみ参加できる
+		if !isPublic {
+			http.Error(w, "このグループは招待制です", http.StatusForbidden)
 			return
 		}
-		// 👇 新しく入った場合にだけ入室通知を送る
-		var username string
-		err = s.DB.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&username)
-		if err != nil {
-			http.Error(w, "ユーザー名の取得に失敗しました", http.StatusInternalServerError)
+		if !s.joinGroupRoom(w, roomID, userID) {
 			return
 		}
+	}
 
-		s.WSHub.Broadcast <- WSMessage{
-			RoomID: roomID,
-			Data: map[string]any{
-				"type": "user_entered",
-				"user": username,
-			},
-		}
+	s.writeRoomMemberNames(w, roomID)
+}
+
+// 新規メンバーとして参加させ、入室通知を送る（BAN されている場合は拒否）
+func (s *Server) joinGroupRoom(w http.ResponseWriter, roomID, userID int) bool {
+	banned, err := s.isBanned(roomID, userID)
+	if err != nil {
+		http.Error(w, "存在チェック失敗", http.StatusInternalServerError)
+		return false
 	}
+	if banned {
+		http.Error(w, "このグループから BAN されています", http.StatusForbidden)
+		return false
+	}
+
+	res, err := s.DB.Exec("INSERT INTO room_members (room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", roomID, userID)
+	if err != nil {
+		http.Error(w, "参加に失敗しました", http.StatusInternalServerError)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return true // 同時に参加済みになった場合は通知しない
+	}
+	return s.announceRoomJoin(w, roomID, userID)
+}
 
+// 👇 新しく入った場合にだけ入室通知とメンバー追加イベントを送る
+// （公開グループへの参加と招待リンクからの参加で共通）
+func (s *Server) announceRoomJoin(w http.ResponseWriter, roomID, userID int) bool {
+	var username string
+	err := s.DB.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&username)
+	if err != nil {
+		http.Error(w, "ユーザー名の取得に失敗しました", http.StatusInternalServerError)
+		return false
+	}
+
+	s.WSHub.Broadcast <- WSMessage{
+		RoomID: roomID,
+		Data: map[string]any{
+			"type": "user_entered",
+			"user": username,
+		},
+	}
+	s.broadcastMemberEvent(roomID, map[string]any{
+		"type":    "member_added",
+		"user_id": userID,
+		"user":    username,
+		"by":      userID,
+	})
+	return true
+}
+
+// ルームの参加者名一覧をレスポンスとして返す
+func (s *Server) writeRoomMemberNames(w http.ResponseWriter, roomID int) {
 	rows, err := s.DB.Query(`
 		SELECT u.username
 		FROM room_members rm
@@ -193,12 +378,21 @@ func (s *Server) JoinGroupRoomHandler(w http.ResponseWriter, r *http.Request) {
 
 // GET /rooms/{room_id}/info ルーム名とグループかどうかを取得
 func (s *Server) GetRoomInfoHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized) // token無効
+		return
+	}
+
 	vars := mux.Vars(r)
-	roomID := vars["room_id"]
+	roomID, err := strconv.Atoi(vars["room_id"])
+	if err != nil {
+		http.Error(w, "無効な room_id", http.StatusBadRequest)
+		return
+	}
 
-	var roomName string
-	var isGroup bool
-	err := s.DB.QueryRow("SELECT room_name, is_group FROM chat_rooms WHERE id = $1", roomID).Scan(&roomName, &isGroup)
+	var room RoomInfo
+	err = scanRoomInfo(s.DB.QueryRow(`SELECT `+roomInfoColumns+` FROM chat_rooms cr WHERE cr.id = $1`, roomID), &room)
 	if err == sql.ErrNoRows {
 		http.Error(w, "ルームが存在していません", http.StatusNotFound)
 		return
@@ -207,10 +401,14 @@ func (s *Server) GetRoomInfoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"room_name": roomName,
-		"is_group":  isGroup,
-	})
+	// 公開グループ以外はメンバーのみ閲覧可能
+	if !room.IsPublic {
+		if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(room)
 }
 
 // POST /rooms/{room_id}/leave グループから退出
@@ -238,12 +436,56 @@ func (s *Server) LeaveGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// room_members 関係を削除
-	_, err = s.DB.Exec("DELETE FROM room_members WHERE room_id = $1 AND user_id = $2", roomID, userID)
+	tx, err := s.DB.Begin()
 	if err != nil {
 		http.Error(w, "退室に失敗しました", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
+
+	// room_members 関係を削除（オーナーの譲渡と同じトランザクションで行う）
+	var role RoomRole
+	err = tx.QueryRow(`
+		DELETE FROM room_members WHERE room_id = $1 AND user_id = $2 RETURNING role
+	`, roomID, userID).Scan(&role)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "退室に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// オーナーが退出した場合は、admin → member → readonly の順で参加が古いメンバーに譲渡
+	newOwnerID := 0
+	if role == RoleOwner {
+		err = tx.QueryRow(`
+			UPDATE room_members SET role = 'owner'
+			WHERE room_id = $1 AND user_id = (
+				SELECT user_id FROM room_members
+				WHERE room_id = $1
+				ORDER BY CASE role WHEN 'admin' THEN 0 WHEN 'member' THEN 1 ELSE 2 END, joined_at, user_id
+				LIMIT 1
+				FOR UPDATE
+			)
+			RETURNING user_id
+		`, roomID).Scan(&newOwnerID)
+		if err != nil && err != sql.ErrNoRows { // 誰も残っていなければ譲渡しない
+			http.Error(w, "オーナーの譲渡に失敗しました", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "退室に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	if newOwnerID != 0 {
+		s.broadcastMemberEvent(roomID, map[string]any{
+			"type":    "member_role_changed",
+			"user_id": newOwnerID,
+			"user":    s.usernameByID(newOwnerID),
+			"role":    RoleOwner,
+			"by":      userID,
+		})
+	}
 
 	// 退室通知を他のユーザーにブロードキャスト
 	s.WSHub.Broadcast <- WSMessage{
@@ -253,6 +495,7 @@ func (s *Server) LeaveGroupHandler(w http.ResponseWriter, r *http.Request) {
 			"user": username,
 		},
 	}
+	s.disconnectRoomMember(roomID, userID)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
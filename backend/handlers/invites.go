@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type CreateInviteRequest struct {
+	ExpiresInHours int  `json:"expires_in_hours"` // 0 = 無期限
+	MaxUses        *int `json:"max_uses"`         // 未指定 = 無制限
+}
+
+type RoomInvite struct {
+	ID        int        `json:"id"`
+	RoomID    int        `json:"room_id"`
+	Code      string     `json:"code"`
+	URL       string     `json:"url"`
+	CreatedBy *int       `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	Uses      int        `json:"uses"`
+	Revoked   bool       `json:"revoked"`
+}
+
+func inviteURL(code string) string {
+	return utils.AppBaseURL() + "/invite/" + code
+}
+
+// POST /rooms/{room_id}/invites 招待リンクを作成（admin 以上）
+func (s *Server) CreateInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermManageMembers); !ok {
+		return
+	}
+
+	var req CreateInviteRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "データの解析に失敗しました", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.MaxUses != nil && *req.MaxUses <= 0 {
+		http.Error(w, "max_uses は 1 以上にしてください", http.StatusBadRequest)
+		return
+	}
+
+	code, err := utils.NewRandomToken(12)
+	if err != nil {
+		http.Error(w, "招待コードの生成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	invite := RoomInvite{RoomID: roomID, Code: code, URL: inviteURL(code), CreatedBy: &userID, MaxUses: req.MaxUses}
+	if req.ExpiresInHours > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		invite.ExpiresAt = &t
+	}
+
+	err = s.DB.QueryRow(`
+		INSERT INTO room_invites (room_id, code, created_by, expires_at, max_uses)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, roomID, code, userID, invite.ExpiresAt, invite.MaxUses).Scan(&invite.ID, &invite.CreatedAt)
+	if err != nil {
+		http.Error(w, "招待リンクの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+// GET /rooms/{room_id}/invites 招待リンク一覧（admin 以上）
+func (s *Server) ListInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermManageMembers); !ok {
+		return
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT id, room_id, code, created_by, created_at, expires_at, max_uses, uses, revoked_at IS NOT NULL
+		FROM room_invites
+		WHERE room_id = $1
+		ORDER BY created_at DESC
+	`, roomID)
+	if err != nil {
+		http.Error(w, "招待リンクの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invites := []RoomInvite{}
+	for rows.Next() {
+		var inv RoomInvite
+		if err := rows.Scan(&inv.ID, &inv.RoomID, &inv.Code, &inv.CreatedBy, &inv.CreatedAt,
+			&inv.ExpiresAt, &inv.MaxUses, &inv.Uses, &inv.Revoked); err != nil {
+			continue
+		}
+		inv.URL = inviteURL(inv.Code)
+		invites = append(invites, inv)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"invites": invites})
+}
+
+// POST /rooms/{room_id}/invites/{invite_id}/revoke 招待リンクを無効化
+func (s *Server) RevokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermManageMembers); !ok {
+		return
+	}
+	inviteID, err := strconv.Atoi(mux.Vars(r)["invite_id"])
+	if err != nil {
+		http.Error(w, "無効な invite_id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.DB.Exec(`
+		UPDATE room_invites SET revoked_at = NOW()
+		WHERE id = $1 AND room_id = $2 AND revoked_at IS NULL
+	`, inviteID, roomID)
+	if err != nil {
+		http.Error(w, "招待リンクの無効化に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "招待リンクが存在しません", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "招待リンクを無効化しました"})
+}
+
+// GET /invites/{code} 招待リンクのプレビュー（参加前にルーム名を表示するため）
+func (s *Server) GetInviteHandler(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+
+	var roomID, memberCount int
+	var roomName string
+	err := s.DB.QueryRow(`
+		SELECT cr.id, cr.room_name,
+		       (SELECT COUNT(*) FROM room_members rm WHERE rm.room_id = cr.id)
+		FROM room_invites i
+		JOIN chat_rooms cr ON cr.id = i.room_id
+		WHERE i.code = $1
+		  AND i.revoked_at IS NULL
+		  AND (i.expires_at IS NULL OR i.expires_at > NOW())
+		  AND (i.max_uses IS NULL OR i.uses < i.max_uses)
+	`, code).Scan(&roomID, &roomName, &memberCount)
+	if err == sql.ErrNoRows {
+		http.Error(w, "招待リンクが無効または期限切れです", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "招待リンクの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"room_id":      roomID,
+		"room_name":    roomName,
+		"member_count": memberCount,
+	})
+}
+
+// POST /invites/{code}/join 招待コードでグループに参加
+func (s *Server) JoinByInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	code := mux.Vars(r)["code"]
+
+	var roomID int
+	err = s.DB.QueryRow(`
+		SELECT room_id FROM room_invites
+		WHERE code = $1
+		  AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND (max_uses IS NULL OR uses < max_uses)
+	`, code).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "招待リンクが無効または期限切れです", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "招待リンクの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// すでにメンバーなら使用回数を消費しない
+	role, err := s.roomRole(roomID, userID)
+	if err != nil {
+		http.Error(w, "存在チェック失敗", http.StatusInternalServerError)
+		return
+	}
+	if role == "" {
+		banned, err := s.isBanned(roomID, userID)
+		if err != nil {
+			http.Error(w, "存在チェック失敗", http.StatusInternalServerError)
+			return
+		}
+		if banned {
+			http.Error(w, "このグループから BAN されています", http.StatusForbidden)
+			return
+		}
+
+		// ✅ 使用回数の加算とメンバー追加を1トランザクションで行う
+		joined, err := s.joinWithInvite(code, roomID, userID)
+		if err == errInviteUnavailable {
+			http.Error(w, "招待リンクが無効または期限切れです", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "参加に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		if !joined {
+			// 同時に参加済みになった場合は何もしない（使用回数も消費しない）
+			json.NewEncoder(w).Encode(map[string]any{
+				"room_id": roomID,
+				"message": "グループに参加しました",
+			})
+			return
+		}
+
+		if !s.announceRoomJoin(w, roomID, userID) {
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"room_id": roomID,
+		"message": "グループに参加しました",
+	})
+}
+
+var errInviteUnavailable = errors.New("招待リンクが無効または期限切れです")
+
+// 招待の使用回数を加算してメンバーに追加する（すでにメンバーなら何もせず false）
+func (s *Server) joinWithInvite(code string, roomID, userID int) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// 使用回数を条件付きで加算（同時参加でも max_uses を超えない）
+	res, err := tx.Exec(`
+		UPDATE room_invites SET uses = uses + 1
+		WHERE code = $1
+		  AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND (max_uses IS NULL OR uses < max_uses)
+	`, code)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, errInviteUnavailable
+	}
+
+	res, err = tx.Exec(`INSERT INTO room_members (room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil // ロールバックして使用回数を戻す
+	}
+	return true, tx.Commit()
+}
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"backend/mail"
+	"backend/oidc"
+	"backend/scanner"
+	"backend/storage"
 	"backend/utils"
 	"database/sql"
 	"encoding/json"
@@ -11,8 +15,14 @@ import (
 )
 
 type Server struct {
-	DB    *sql.DB
-	WSHub *WebSocketHub
+	DB               *sql.DB
+	WSHub            *WebSocketHub
+	PasswordPolicy   utils.PasswordPolicy   // パスワード強度ルール
+	Mailer           mail.Sender            // パスワードリセット等のメール送信（nil ならリセット無効）
+	OIDC             *oidc.Provider         // シングルサインオン（未設定なら nil）
+	Storage          storage.Storage        // 添付ファイル・アイコンの保存先
+	AttachmentPolicy utils.AttachmentPolicy // 添付ファイルの形式・サイズのルール
+	Scanner          scanner.Scanner        // 添付ファイルのマルウェア検査（未設定なら nil）
 }
 
 type LoginRequest struct {
@@ -43,12 +53,32 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := utils.GenerateJWT(userID, req.Username) ////// 全ての場所で userID を使用する
-	if err != nil {
+	if err := s.issueLoginCookie(w, userID, req.Username); err != nil {
 		http.Error(w, "トークンの生成に失敗しました", http.StatusInternalServerError) // tokenの生成が失敗しました
 		return
 	}
 
+	// ✅ レスポンスとして username を返す（トークンは返さない）
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "ログインに成功しました", // 登録成功
+		"username": req.Username,  // 使用者名稱
+	})
+}
+
+// ✅ セッションを作成し、JWT を HttpOnly Cookie として発行する
+// パスワードログイン・OIDC ログインで共通
+func (s *Server) issueLoginCookie(w http.ResponseWriter, userID int, username string) error {
+	// ログインごとにセッションを作成（パスワード変更時などに失効させるため）
+	sessionID, err := utils.CreateSession(s.DB, userID)
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateJWT(userID, username, sessionID) ////// 全ての場所で userID を使用する
+	if err != nil {
+		return err
+	}
+
 	// ✅ トークンを HttpOnly Cookie として保存（JS からはアクセス不可）
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token",                            // Cookie の名前
@@ -59,10 +89,5 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		Expires:  time.Now().Add(7 * 24 * time.Hour), // 1週間有効
 		// Secure: true,                      // 本番環境では HTTPS のみ
 	})
-
-	// ✅ レスポンスとして username を返す（トークンは返さない）
-	json.NewEncoder(w).Encode(map[string]string{
-		"message":  "ログインに成功しました", // 登録成功
-		"username": req.Username,  // 使用者名稱
-	})
+	return nil
 }
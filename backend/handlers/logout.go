@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"backend/utils"
 	"net/http"
 )
 
 // POST /logout
 // Cookie（JWTトークン）を削除してログアウト処理を行う
 func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	// サーバー側のセッションも失効させる（トークンが有効な場合のみ）
+	if claims, err := utils.GetClaimsFromRequest(r); err == nil && claims.ID != "" {
+		_ = utils.RevokeSession(s.DB, claims.ID)
+	}
+
 	// クッキーを即時に無効化する（MaxAge = -1）
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token", // JWTトークンが保存されているクッキー名
@@ -38,6 +38,11 @@ func (s *Server) SaveMentionsAndNotify(messageID int, usernames []string) {
 			continue
 		}
 
+		// ミュート中のルームではメンション通知を送らない（未読のメンション数には含まれる）
+		if s.mutedRoomMembers(roomID)[userID] {
+			continue
+		}
+
 		var senderName string
 		s.DB.QueryRow("SELECT username FROM users WHERE id = $1", senderID).Scan(&senderName)
 
@@ -103,11 +108,9 @@ func (s *Server) GetMentionNotifications(w http.ResponseWriter, r *http.Request)
 		FROM mentions me
 		JOIN messages m ON me.message_id = m.id
 		JOIN users u ON m.sender_id = u.id
+		JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = $1
 		WHERE me.mention_target_id = $1
-		AND NOT EXISTS (
-			SELECT 1 FROM message_reads r
-			WHERE r.message_id = me.message_id AND r.user_id = $1
-		)
+		AND m.id > COALESCE(rm.last_read_message_id, 0)
 	`, userID)
 	if err != nil {
 		http.Error(w, "DB error", 500)
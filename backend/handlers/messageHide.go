@@ -48,11 +48,27 @@ func (s *Server) RevokeMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = s.DB.Exec("DELETE FROM messages WHERE id = $1", msgID)
+	// ピン留めは削除と同時に消えるため、解除イベントも送る
+	var pinned bool
+	_ = s.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM message_pins WHERE message_id = $1)", msgID).Scan(&pinned)
+
+	// 添付ファイルは他のメッセージと共有されていなければ一緒に削除される
+	err = s.deleteMessage(r.Context(), msgID)
 	if err != nil {
 		http.Error(w, "削除に失敗しました", http.StatusInternalServerError)
 		return
 	}
+	if pinned {
+		s.WSHub.Broadcast <- WSMessage{
+			RoomID: roomID,
+			Data: map[string]any{
+				"type":       "message_unpinned",
+				"room_id":    roomID,
+				"message_id": msgID,
+				"by":         userID,
+			},
+		}
+	}
 
 	// WebSocket 経由で通知（撤回）
 	s.WSHub.Broadcast <- WSMessage{
@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"backend/utils"
-	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -40,6 +39,11 @@ func (s *Server) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ✅ ルームのメンバーかつ読み取り専用でない（トークンのルーム制限も含む）ことを確認
+	if _, ok := s.requireRoomPermission(w, r, req.RoomID, userID, PermPostMessage); !ok {
+		return
+	}
+
 	now := time.Now()
 
 	// ✅ データベースに挿入して ID を取得
@@ -56,6 +60,9 @@ func (s *Server) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ✅ 自分の投稿までは既読扱い
+	_ = s.advanceReadCursor(req.RoomID, userID, messageID)
+
 	// ✅ メンション保存
 	if len(req.Mentions) > 0 {
 		s.SaveMentionsAndNotify(messageID, req.Mentions)
@@ -86,30 +93,19 @@ func (s *Server) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(req.Content) < 9 || req.Content[:9] != "reaction:" {
-		unreadMap := s.GetUnreadMapForRoom(req.RoomID)
-
-		s.WSHub.Broadcast <- WSMessage{
-			RoomID: req.RoomID,
-			Data: map[string]any{
-				"type":       "unread_update",
-				"room_id":    req.RoomID,
-				"unread_map": unreadMap,
-			},
-		}
-		s.WSHub.Broadcast <- WSMessage{
-			RoomID: 0,
-			Data: map[string]any{
-				"type":       "unread_update",
-				"room_id":    req.RoomID,
-				"unread_map": unreadMap,
-			},
-		}
+		s.broadcastUnread(req.RoomID)
 	}
 
 	log.Println("✅ データベースへの書き込みとブロードキャスト成功") // 資料庫寫入與廣播成功
 	w.WriteHeader(http.StatusCreated)
 }
 
+// ✅ ルームの未読数を各メンバー本人に通知（ルームとロビーの両方の接続に届く）
+func (s *Server) broadcastUnread(roomID int) {
+	// ミュート中のメンバーには未読通知を送らない
+	s.sendUnreadUpdate(roomID, s.notifyUnreadMapForRoom(roomID), nil)
+}
+
 // GET /messages ルームのメッセージ一覧を取得
 func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	roomIDStr := r.URL.Query().Get("room_id")
@@ -124,6 +120,10 @@ func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "ログインが必要です", http.StatusUnauthorized)
 		return
 	}
+	// ✅ メンバー以外にはメッセージ（添付 URL を含む）を返さない
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
 
 	type MessageResponse struct {
 		ID           int       `json:"id"`
@@ -134,17 +134,19 @@ func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		CreatedAt    time.Time `json:"created_at"`
 		UpdatedAt    time.Time `json:"updated_at"`
 		ThreadRootID *int      `json:"thread_root_id,omitempty"`
-		Attachment   *string   `json:"attachment,omitempty"`
+		// 添付ファイル（複数可）
+		Attachments []MessageAttachment `json:"attachments,omitempty"`
+		// 旧クライアント向け: 先頭の添付ファイルの URL と元のファイル名
+		Attachment     *string `json:"attachment,omitempty"`
+		AttachmentName *string `json:"attachment_name,omitempty"`
 	}
 
 	rows, err := s.DB.Query(`
 		SELECT 
 			m.id, m.room_id, m.sender_id, u.username, 
-			m.content, m.created_at, m.updated_at, m.thread_root_id,
-			a.file_name
+			m.content, m.created_at, m.updated_at, m.thread_root_id
 		FROM messages m
 		JOIN users u ON m.sender_id = u.id
-		LEFT JOIN message_attachments a ON a.message_id = m.id
 		WHERE m.room_id = $1
 		AND NOT EXISTS (
 			SELECT 1 FROM message_hidden h 
@@ -159,13 +161,12 @@ func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	var messages []MessageResponse
+	var ids []int
 	for rows.Next() {
 		var msg MessageResponse
-		var attachment sql.NullString
 		if err := rows.Scan(
 			&msg.ID, &msg.RoomID, &msg.SenderID, &msg.Sender,
 			&msg.Content, &msg.CreatedAt, &msg.UpdatedAt, &msg.ThreadRootID,
-			&attachment,
 		); err != nil {
 			log.Println("❌ データ読み取り失敗:", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -173,10 +174,23 @@ func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(map[string]string{"error": "データの取得に失敗しました"})
 			return
 		}
-		if attachment.Valid {
-			msg.Attachment = &attachment.String
-		}
 		messages = append(messages, msg)
+		ids = append(ids, msg.ID)
+	}
+
+	// ✅ 添付ファイルは別クエリでまとめて取得（JOIN だと添付の数だけ行が重複するため）
+	attachments, err := s.messageAttachments(ids)
+	if err != nil {
+		log.Println("❌ 添付ファイルの取得失敗:", err)
+		http.Error(w, "データベースのクエリに失敗しました", http.StatusInternalServerError)
+		return
+	}
+	for i := range messages {
+		if atts := attachments[messages[i].ID]; len(atts) > 0 {
+			messages[i].Attachments = atts
+			messages[i].Attachment = &atts[0].URL
+			messages[i].AttachmentName = &atts[0].Name
+		}
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{"messages": messages})
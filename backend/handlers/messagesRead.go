@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"backend/utils"
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -26,12 +27,20 @@ func (s *Server) MarkMessageAsReadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// メッセージの内容と属するルームIDを取得
 	var content string
-	err = s.DB.QueryRow("SELECT content FROM messages WHERE id = $1", messageID).Scan(&content)
-	if err != nil {
+	var roomID int
+	err = s.DB.QueryRow("SELECT content, room_id FROM messages WHERE id = $1", messageID).Scan(&content, &roomID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "メッセージが存在しません", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "メッセージの取得に失敗しました", http.StatusInternalServerError)
 		return
 	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
 	if len(content) >= 9 && content[:9] == "reaction:" {
 		// 如果是 reaction，跳过写入及广播
 		w.WriteHeader(http.StatusOK)
@@ -41,19 +50,17 @@ func (s *Server) MarkMessageAsReadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// メッセージが属するルームIDを取得
-	var roomID int
-	err = s.DB.QueryRow("SELECT room_id FROM messages WHERE id = $1", messageID).Scan(&roomID)
-	if err != nil {
-		http.Error(w, "ルームIDの取得に失敗しました", http.StatusInternalServerError) // 查詢 room_id 失敗
+	// ✅ 既読位置をこのメッセージまで進める（それ以前のメッセージも既読扱い）
+	if err := s.advanceReadCursor(roomID, userID, messageID); err != nil {
+		http.Error(w, "データベースの書き込みに失敗しました", http.StatusInternalServerError) // 寫入資料庫失敗
 		return
 	}
 
-	//// すでに存在する場合は、現在時刻で更新
+	//// 画面に表示されたメッセージのみ個別の既読記録を残す（既読時刻の表示用）
 	_, err = s.DB.Exec(`
 		INSERT INTO message_reads (message_id, user_id, read_at)
 		VALUES ($1, $2, NOW())
-		ON CONFLICT (message_id, user_id) DO UPDATE SET read_at = NOW()
+		ON CONFLICT (message_id, user_id) DO NOTHING
 	`, messageID, userID)
 	if err != nil {
 		http.Error(w, "データベースの書き込みに失敗しました", http.StatusInternalServerError) // 寫入資料庫失敗
@@ -61,48 +68,34 @@ func (s *Server) MarkMessageAsReadHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// 現在このメッセージを既読にしているすべてのユーザー名を取得
-	rows, err := s.DB.Query(`
-		SELECT u.username
-		FROM message_reads mr
-		JOIN users u ON mr.user_id = u.id
-		WHERE mr.message_id = $1
-	`, messageID)
+	readers, err := s.messageReaders(messageID)
 	if err != nil {
 		http.Error(w, "既読者の取得に失敗しました", http.StatusInternalServerError) // 查詢已讀失敗
 		return
 	}
-	defer rows.Close()
-
-	var readers []string
-	for rows.Next() {
-		var name string
-		_ = rows.Scan(&name)
-		readers = append(readers, name)
-	}
 
 	// 既読ステータスをブロードキャスト（聊天室内）
-	unreadMap := s.GetUnreadMapForRoom(roomID)
-
-	s.WSHub.Broadcast <- WSMessage{
-		RoomID: roomID,
-		Data: map[string]any{
-			"type":       "read_update",
-			"message_id": messageID,
-			"readers":    readers,
-			"unread_map": unreadMap,
-		},
+	// ⚠️ 既読を非表示にしているユーザー、または既読表示が無効なルームでは送らない
+	visible, err := s.readReceiptVisible(roomID, userID)
+	if err != nil {
+		http.Error(w, "既読設定の取得に失敗しました", http.StatusInternalServerError)
+		return
 	}
-
-	// ✅ 同步推送到 room_id = 0（聊天室首页）
-	s.WSHub.Broadcast <- WSMessage{
-		RoomID: 0,
-		Data: map[string]any{
-			"type":       "unread_update",
-			"room_id":    roomID,
-			"unread_map": unreadMap,
-		},
+	if visible {
+		s.WSHub.Broadcast <- WSMessage{
+			RoomID: roomID,
+			Data: map[string]any{
+				"type":       "read_update",
+				"message_id": messageID,
+				"readers":    readers,
+			},
+		}
 	}
 
+	// ✅ 変わったのは本人の未読数だけなので本人にだけ送る（聊天室首页にも届く）
+	unreadMap := s.GetUnreadMapForRoom(roomID)
+	s.sendUnreadUpdate(roomID, map[int]int{userID: unreadMap[userID]}, nil)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "メッセージは既読にマークされました", // 訊息已標記為已讀
@@ -131,15 +124,13 @@ func (s *Server) GetUnreadMessageCountHandler(w http.ResponseWriter, r *http.Req
 	err = s.DB.QueryRow(`
 		SELECT COUNT(*)
 		FROM messages m
+		JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = $2
 		WHERE m.room_id = $1
 		AND m.sender_id != $2
 		AND NOT m.content LIKE 'reaction:%'
-		AND NOT EXISTS (
-			SELECT 1 FROM message_reads mr 
-			WHERE mr.message_id = m.id AND mr.user_id = $2
-		)
+		AND m.id > COALESCE(rm.last_read_message_id, 0)
 	`, roomID, userID).Scan(&count)
-	//// 指定ユーザーがこのメッセージを読んだかどうか確認（未読ならカウント）
+	//// 既読位置より新しいメッセージを未読としてカウント
 	if err != nil {
 		http.Error(w, "クエリの実行に失敗しました", http.StatusInternalServerError) // 查詢失敗
 		return
@@ -151,8 +142,14 @@ func (s *Server) GetUnreadMessageCountHandler(w http.ResponseWriter, r *http.Req
 }
 
 // GET /messages/{message_id}/readers
-// メッセージの既読ユーザー一覧を取得
+// メッセージの既読ユーザー一覧を取得（ルームのメンバーのみ）
 func (s *Server) GetMessageReadsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+
 	vars := mux.Vars(r)
 	messageID, err := strconv.Atoi(vars["message_id"])
 	if err != nil {
@@ -160,23 +157,23 @@ func (s *Server) GetMessageReadsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	rows, err := s.DB.Query(`
-		SELECT u.username
-		FROM message_reads mr
-		JOIN users u ON mr.user_id = u.id
-		WHERE mr.message_id = $1
-	`, messageID)
-	if err != nil {
-		http.Error(w, "既読ユーザーの取得に失敗しました", http.StatusInternalServerError) // 查詢既読失敗
+	var roomID int
+	err = s.DB.QueryRow("SELECT room_id FROM messages WHERE id = $1", messageID).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "メッセージが存在しません", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "ルームIDの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
 		return
 	}
-	defer rows.Close()
 
-	var readers []string
-	for rows.Next() {
-		var name string
-		_ = rows.Scan(&name)
-		readers = append(readers, name)
+	readers, err := s.messageReaders(messageID)
+	if err != nil {
+		http.Error(w, "既読ユーザーの取得に失敗しました", http.StatusInternalServerError) // 查詢既読失敗
+		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -184,20 +181,106 @@ func (s *Server) GetMessageReadsHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// POST /rooms/{room_id}/mark-unread
+// 既読位置を戻して未読にする（message_id 指定時はそのメッセージ以降、未指定時は最新の受信メッセージ）
+func (s *Server) MarkRoomUnreadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+
+	roomID, err := strconv.Atoi(mux.Vars(r)["room_id"])
+	if err != nil {
+		http.Error(w, "無効なルームID", http.StatusBadRequest) // 無效聊天室 ID
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
+
+	var req struct {
+		MessageID *int `json:"message_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "リクエスト形式が正しくありません", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 未読にする先頭メッセージを決める
+	var fromID int
+	if req.MessageID != nil {
+		err = s.DB.QueryRow(`SELECT id FROM messages WHERE id = $1 AND room_id = $2`, *req.MessageID, roomID).Scan(&fromID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "メッセージが存在しません", http.StatusNotFound)
+			return
+		}
+	} else {
+		err = s.DB.QueryRow(`
+			SELECT COALESCE(MAX(id), 0) FROM messages
+			WHERE room_id = $1 AND sender_id <> $2 AND NOT content LIKE 'reaction:%'
+		`, roomID, userID).Scan(&fromID)
+	}
+	if err != nil {
+		http.Error(w, "メッセージの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// ✅ 既読位置を先頭メッセージの直前に戻し、手動未読フラグを立てる
+	_, err = s.DB.Exec(`
+		UPDATE room_members
+		SET last_read_message_id = CASE WHEN $3 > 0
+				THEN (SELECT COALESCE(MAX(id), 0) FROM messages WHERE room_id = $1 AND id < $3)
+				ELSE last_read_message_id END,
+		    last_read_at = NOW(),
+		    marked_unread = true
+		WHERE room_id = $1 AND user_id = $2
+	`, roomID, userID, fromID)
+	if err != nil {
+		http.Error(w, "データベースの書き込みに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// 個別の既読記録も取り消す（既読者一覧から外れるように）
+	if fromID > 0 {
+		_, err = s.DB.Exec(`
+			DELETE FROM message_reads
+			WHERE user_id = $2
+			  AND message_id IN (SELECT id FROM messages WHERE room_id = $1 AND id >= $3)
+		`, roomID, userID, fromID)
+		if err != nil {
+			http.Error(w, "データベースの書き込みに失敗しました", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// ✅ 他の端末にも未読数を反映（未読に戻したことは本人にだけ伝える）
+	unreadMap := s.GetUnreadMapForRoom(roomID)
+	s.sendUnreadUpdate(roomID, map[int]int{userID: unreadMap[userID]}, map[string]any{
+		"marked_unread": map[int]bool{userID: true},
+	})
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"room_id":       roomID,
+		"unread_count":  unreadMap[userID],
+		"marked_unread": true,
+	})
+}
+
+// ルームの各メンバーの未読数（既読位置より新しい他人のメッセージ数）
 func (s *Server) GetUnreadMapForRoom(roomID int) map[int]int {
 	result := make(map[int]int)
 
 	rows, err := s.DB.Query(`
 		SELECT rm.user_id, COUNT(m.id)
 		FROM room_members rm
-		JOIN messages m ON m.room_id = rm.room_id
-		WHERE rm.room_id = $1
+		LEFT JOIN messages m ON m.room_id = rm.room_id
+		  AND m.id > COALESCE(rm.last_read_message_id, 0)
 		  AND m.sender_id != rm.user_id
-			AND NOT m.content LIKE 'reaction:%'
-		  AND NOT EXISTS (
-		    SELECT 1 FROM message_reads r
-		    WHERE r.message_id = m.id AND r.user_id = rm.user_id
-		  )
+		  AND NOT m.content LIKE 'reaction:%'
+		WHERE rm.room_id = $1
 		GROUP BY rm.user_id
 	`, roomID)
 	if err != nil {
@@ -213,3 +296,81 @@ func (s *Server) GetUnreadMapForRoom(roomID int) map[int]int {
 
 	return result
 }
+
+// ✅ 未読数を各ユーザー本人の接続にだけ通知する
+// 他人の未読数から既読のタイミングがわかってしまうため、unread_map には本人の分しか入れない
+// extra には通知に追加するフィールドを指定する
+func (s *Server) sendUnreadUpdate(roomID int, unreadMap map[int]int, extra map[string]any) {
+	for userID, count := range unreadMap {
+		data := map[string]any{
+			"type":       "unread_update",
+			"room_id":    roomID,
+			"unread_map": map[int]int{userID: count},
+		}
+		for k, v := range extra {
+			data[k] = v
+		}
+		s.WSHub.SendToUser <- UserMessage{UserID: userID, Data: data}
+	}
+}
+
+// ✅ 既読位置を messageID まで進める（後退はしない）
+func (s *Server) advanceReadCursor(roomID, userID, messageID int) error {
+	_, err := s.DB.Exec(`
+		UPDATE room_members
+		SET last_read_message_id = GREATEST(COALESCE(last_read_message_id, 0), $3),
+		    last_read_at = NOW(),
+		    marked_unread = false
+		WHERE room_id = $1 AND user_id = $2
+	`, roomID, userID, messageID)
+	return err
+}
+
+// ユーザーの既読をルーム内に表示してよいか
+func (s *Server) readReceiptVisible(roomID, userID int) (bool, error) {
+	var visible bool
+	err := s.DB.QueryRow(`
+		SELECT cr.read_receipts_enabled AND NOT u.hide_read_receipts
+		FROM chat_rooms cr, users u
+		WHERE cr.id = $1 AND u.id = $2
+	`, roomID, userID).Scan(&visible)
+	return visible, err
+}
+
+// メッセージの既読者（送信者以外）
+// 既読位置がメッセージ以降のメンバー + 個別の既読記録があるメンバー（退室したユーザーは含めない）
+// 既読を非表示にしているユーザーは除外し、既読表示が無効なルームでは空になる
+func (s *Server) messageReaders(messageID int) ([]string, error) {
+	rows, err := s.DB.Query(`
+		SELECT u.username
+		FROM messages m
+		JOIN chat_rooms cr ON cr.id = m.room_id AND cr.read_receipts_enabled
+		JOIN room_members rm ON rm.room_id = m.room_id
+		JOIN users u ON u.id = rm.user_id AND NOT u.hide_read_receipts
+		WHERE m.id = $1
+		  AND rm.user_id <> m.sender_id
+		  AND rm.last_read_message_id >= m.id
+		UNION
+		SELECT u.username
+		FROM message_reads mr
+		JOIN messages m ON m.id = mr.message_id
+		JOIN chat_rooms cr ON cr.id = m.room_id AND cr.read_receipts_enabled
+		JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = mr.user_id
+		JOIN users u ON u.id = mr.user_id AND NOT u.hide_read_receipts
+		WHERE mr.message_id = $1
+		  AND mr.user_id <> m.sender_id
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var readers []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			readers = append(readers, name)
+		}
+	}
+	return readers, nil
+}
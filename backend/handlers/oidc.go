@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"backend/oidc"
+	"backend/utils"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 認可リクエスト（state）の有効期限
+const oidcStateTTL = 10 * time.Minute
+
+// ログインを開始したブラウザに state を紐付ける Cookie（ログイン CSRF 対策）
+const oidcStateCookie = "oidc_state"
+
+// GET /auth/oidc/login
+// state・nonce・PKCE の code_verifier を生成して IdP へリダイレクト
+func (s *Server) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if s.OIDC == nil {
+		http.Error(w, "シングルサインオンは設定されていません", http.StatusServiceUnavailable)
+		return
+	}
+
+	state, err1 := utils.NewRandomToken(24)
+	nonce, err2 := utils.NewRandomToken(24)
+	verifier, err3 := utils.NewRandomToken(48) // 43〜128 文字（RFC 7636）
+	if err1 != nil || err2 != nil || err3 != nil {
+		http.Error(w, "トークンの生成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	_, err := s.DB.Exec(`
+		INSERT INTO oidc_auth_requests (state, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, state, nonce, verifier, time.Now().Add(oidcStateTTL))
+	if err != nil {
+		http.Error(w, "ログイン要求の保存に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// 期限切れの要求を掃除
+	_, _ = s.DB.Exec(`DELETE FROM oidc_auth_requests WHERE expires_at < NOW()`)
+
+	// IdP から戻ってくるトップレベルの GET で送られるよう SameSite=Lax
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcStateTTL / time.Second),
+		// Secure: true, // 本番環境では HTTPS のみ
+	})
+
+	http.Redirect(w, r, s.OIDC.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// GET /auth/oidc/callback
+// 認可コードを交換し、ユーザーを紐付け（または自動作成）して JWT Cookie を発行
+func (s *Server) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if s.OIDC == nil {
+		http.Error(w, "シングルサインオンは設定されていません", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		http.Error(w, "IdP でのログインに失敗しました: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	// ✅ ログインを開始したブラウザか確認（別人の認可コードでログインさせる攻撃を防ぐ）
+	state := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "ログイン要求が無効または期限切れです", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
+
+	// ✅ state を一回限りで取り出す（CSRF・リプレイ対策）
+	var nonce, verifier string
+	err = s.DB.QueryRow(`
+		DELETE FROM oidc_auth_requests
+		WHERE state = $1 AND expires_at > NOW()
+		RETURNING nonce, code_verifier
+	`, state).Scan(&nonce, &verifier)
+	if err == sql.ErrNoRows {
+		http.Error(w, "ログイン要求が無効または期限切れです", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "ログイン要求の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	claims, err := s.OIDC.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Println("❌ OIDC トークン交換失敗:", err)
+		http.Error(w, "IdP からのトークン検証に失敗しました", http.StatusUnauthorized)
+		return
+	}
+
+	userID, username, err := s.resolveOIDCUser(claims)
+	if err == errOIDCUserNotAllowed {
+		http.Error(w, "このアカウントはアプリに登録されていません", http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Println("❌ OIDC ユーザーの紐付けに失敗:", err)
+		http.Error(w, "ユーザーの紐付けに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.issueLoginCookie(w, userID, username); err != nil {
+		http.Error(w, "トークンの生成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, s.OIDC.Config().PostLoginURL, http.StatusFound)
+}
+
+var errOIDCUserNotAllowed = fmt.Errorf("OIDC ユーザーの自動作成が無効です")
+
+// IdP のアカウントに対応するローカルユーザーを返す
+// 1. user_identities に紐付け済み → そのユーザー
+// 2. 検証済みメールが一致（LinkByEmail、既定は無効）→ 紐付けを追加
+// 3. AutoProvision → 新規ユーザーを作成して紐付け
+func (s *Server) resolveOIDCUser(c *oidc.IDTokenClaims) (int, string, error) {
+	issuer := s.OIDC.Issuer()
+	cfg := s.OIDC.Config()
+
+	var userID int
+	var username string
+	err := s.DB.QueryRow(`
+		SELECT u.id, u.username
+		FROM user_identities ui
+		JOIN users u ON u.id = ui.user_id
+		WHERE ui.issuer = $1 AND ui.subject = $2
+	`, issuer, c.Subject).Scan(&userID, &username)
+	if err == nil {
+		return userID, username, nil
+	} else if err != sql.ErrNoRows {
+		return 0, "", err
+	}
+
+	if cfg.LinkByEmail && c.EmailVerified && c.Email != "" {
+		err = s.DB.QueryRow(`SELECT id, username FROM users WHERE lower(email) = lower($1)`, c.Email).Scan(&userID, &username)
+		if err == nil {
+			return userID, username, s.linkIdentity(userID, issuer, c)
+		} else if err != sql.ErrNoRows {
+			return 0, "", err
+		}
+	}
+
+	if !cfg.AutoProvision {
+		return 0, "", errOIDCUserNotAllowed
+	}
+
+	username, err = s.availableUsername(oidcUsernameCandidate(c))
+	if err != nil {
+		return 0, "", err
+	}
+
+	var email sql.NullString
+	if addr, err := normalizeEmail(c.Email); c.EmailVerified && err == nil {
+		// メールが既存ユーザーと重複する場合は保存しない
+		var taken bool
+		if err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1))`, addr).Scan(&taken); err == nil && !taken {
+			email = sql.NullString{String: addr, Valid: true}
+		}
+	}
+
+	// パスワードは空（パスワードログイン不可、リセットで設定可能）
+	err = s.DB.QueryRow(`
+		INSERT INTO users (username, password_hash, email) VALUES ($1, '', $2) RETURNING id
+	`, username, email).Scan(&userID)
+	if err != nil {
+		return 0, "", err
+	}
+	log.Printf("✅ OIDC ユーザーを自動作成: %s (user_id=%d)\n", username, userID)
+
+	return userID, username, s.linkIdentity(userID, issuer, c)
+}
+
+func (s *Server) linkIdentity(userID int, issuer string, c *oidc.IDTokenClaims) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (issuer, subject) DO NOTHING
+	`, userID, issuer, c.Subject, c.Email)
+	return err
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^\p{L}\p{N}._-]+`)
+
+// ユーザー名の候補（preferred_username → メールのローカル部 → name → sub）
+func oidcUsernameCandidate(c *oidc.IDTokenClaims) string {
+	candidates := []string{c.PreferredUsername, strings.SplitN(c.Email, "@", 2)[0], c.Name, c.Subject}
+	for _, cand := range candidates {
+		cand = usernameUnsafe.ReplaceAllString(strings.TrimSpace(cand), "_")
+		if cand != "" {
+			return cand
+		}
+	}
+	return "user"
+}
+
+// 既存ユーザー名と重複しない名前を返す（name, name_2, name_3 ...）
+func (s *Server) availableUsername(base string) (string, error) {
+	name := base
+	for i := 2; i < 1000; i++ {
+		var taken bool
+		if err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`, name).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	return "", fmt.Errorf("ユーザー名を決定できません: %s", base)
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"backend/oidc"
+	"backend/oidc/mockprovider"
+)
+
+// ログインフローで使うテーブルだけを持つインメモリのデータベース
+type fakeOIDCStore struct {
+	mu         sync.Mutex
+	requests   map[string][2]string // state -> nonce, code_verifier
+	users      map[string]int       // username -> id
+	identities map[string]int       // issuer + "|" + subject -> user id
+	sessions   int
+}
+
+func newFakeOIDCDB(t *testing.T) (*sql.DB, *fakeOIDCStore) {
+	t.Helper()
+	store := &fakeOIDCStore{
+		requests:   make(map[string][2]string),
+		users:      make(map[string]int),
+		identities: make(map[string]int),
+	}
+	db := sql.OpenDB(fakeConnector{store})
+	t.Cleanup(func() { db.Close() })
+	return db, store
+}
+
+// ハンドラーが発行するクエリを内容で判定して処理する
+func (f *fakeOIDCStore) run(query string, args []driver.Value) (*fakeRows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	str := func(i int) string { s, _ := args[i].(string); return s }
+	switch {
+	case strings.Contains(query, "INSERT INTO oidc_auth_requests"):
+		f.requests[str(0)] = [2]string{str(1), str(2)}
+	case strings.Contains(query, "DELETE FROM oidc_auth_requests") && strings.Contains(query, "RETURNING"):
+		req, ok := f.requests[str(0)]
+		delete(f.requests, str(0))
+		if !ok {
+			return &fakeRows{cols: []string{"nonce", "code_verifier"}}, nil
+		}
+		return &fakeRows{cols: []string{"nonce", "code_verifier"}, vals: [][]driver.Value{{req[0], req[1]}}}, nil
+	case strings.Contains(query, "DELETE FROM oidc_auth_requests"):
+	case strings.Contains(query, "FROM user_identities"):
+		rows := &fakeRows{cols: []string{"id", "username"}}
+		if id, ok := f.identities[str(0)+"|"+str(1)]; ok {
+			for name, uid := range f.users {
+				if uid == id {
+					rows.vals = [][]driver.Value{{int64(id), name}}
+				}
+			}
+		}
+		return rows, nil
+	case strings.Contains(query, "WHERE username = $1"):
+		_, taken := f.users[str(0)]
+		return &fakeRows{cols: []string{"exists"}, vals: [][]driver.Value{{taken}}}, nil
+	case strings.Contains(query, "lower(email)"):
+		return &fakeRows{cols: []string{"exists"}, vals: [][]driver.Value{{false}}}, nil
+	case strings.Contains(query, "INSERT INTO users"):
+		id := len(f.users) + 1
+		f.users[str(0)] = id
+		return &fakeRows{cols: []string{"id"}, vals: [][]driver.Value{{int64(id)}}}, nil
+	case strings.Contains(query, "INSERT INTO user_identities"):
+		f.identities[str(1)+"|"+str(2)] = int(args[0].(int64))
+	case strings.Contains(query, "INSERT INTO sessions"):
+		f.sessions++
+	default:
+		return nil, fmt.Errorf("想定外のクエリ: %s", query)
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeConnector struct{ store *fakeOIDCStore }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ store *fakeOIDCStore }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.store, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("トランザクションは未対応")
+}
+
+type fakeStmt struct {
+	store *fakeOIDCStore
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := s.store.run(s.query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.store.run(s.query, args)
+}
+
+type fakeRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
+
+const (
+	testRedirectURL  = "http://app.test/auth/oidc/callback"
+	testPostLoginURL = "http://app.test/chatroom"
+)
+
+// モックプロバイダーと、それに接続した Server を用意する
+func newOIDCTestServer(t *testing.T) (*Server, *fakeOIDCStore) {
+	t.Helper()
+	var mock *mockprovider.Provider
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(idp.Close)
+
+	var err error
+	mock, err = mockprovider.New(idp.URL)
+	if err != nil {
+		t.Fatalf("mockprovider.New: %v", err)
+	}
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:        idp.URL,
+		ClientID:      "chat-app",
+		RedirectURL:   testRedirectURL,
+		Scopes:        []string{"profile", "email"},
+		AutoProvision: true,
+		PostLoginURL:  testPostLoginURL,
+	})
+	if err != nil {
+		t.Fatalf("oidc.NewProvider: %v", err)
+	}
+
+	db, store := newFakeOIDCDB(t)
+	return &Server{DB: db, OIDC: provider}, store
+}
+
+// /auth/oidc/login を呼び、IdP で username としてログインした後のコールバック URL と state Cookie を返す
+func startOIDCLogin(t *testing.T, s *Server, username string) (*url.URL, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.OIDCLoginHandler(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	var stateCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			stateCookie = c
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("login: state Cookie が設定されていない")
+	}
+
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("login: Location: %v", err)
+	}
+	q := authURL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("login: PKCE / nonce がない: %s", authURL)
+	}
+	if q.Get("state") != stateCookie.Value {
+		t.Fatalf("login: state = %q, Cookie = %q", q.Get("state"), stateCookie.Value)
+	}
+	q.Set("login_hint", username) // モックはフォームを出さずに即時承認する
+	authURL.RawQuery = q.Encode()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL.String())
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(callback.String(), testRedirectURL) {
+		t.Fatalf("authorize: Location = %q", resp.Header.Get("Location"))
+	}
+	return callback, stateCookie
+}
+
+func oidcCallback(s *Server, callback *url.URL, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+callback.RawQuery, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.OIDCCallbackHandler(rec, req)
+	return rec
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	s, store := newOIDCTestServer(t)
+	callback, cookie := startOIDCLogin(t, s, "alice")
+
+	rec := oidcCallback(s, callback, cookie)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback: status = %d, want %d (%s)", rec.Code, http.StatusFound, rec.Body.String())
+	}
+	if loc := rec.Header().Get("Location"); loc != testPostLoginURL {
+		t.Errorf("callback: Location = %q, want %q", loc, testPostLoginURL)
+	}
+	var token bool
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "token" && c.Value != "" {
+			token = true
+		}
+	}
+	if !token {
+		t.Error("callback: ログイン Cookie が発行されていない")
+	}
+	if _, ok := store.users["alice"]; !ok {
+		t.Errorf("users = %v, want alice を自動作成", store.users)
+	}
+	if len(store.identities) != 1 || store.sessions != 1 {
+		t.Errorf("identities = %v, sessions = %d, want 1 件ずつ", store.identities, store.sessions)
+	}
+
+	// 同じ state は二度使えない
+	if rec := oidcCallback(s, callback, cookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("replay: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// 2回目のログインは紐付け済みのユーザーになる（新規作成しない）
+	callback, cookie = startOIDCLogin(t, s, "alice")
+	if rec := oidcCallback(s, callback, cookie); rec.Code != http.StatusFound {
+		t.Fatalf("second login: status = %d, want %d (%s)", rec.Code, http.StatusFound, rec.Body.String())
+	}
+	if len(store.users) != 1 || store.sessions != 2 {
+		t.Errorf("second login: users = %v, sessions = %d", store.users, store.sessions)
+	}
+}
+
+func TestOIDCCallbackRejectsMissingStateCookie(t *testing.T) {
+	s, store := newOIDCTestServer(t)
+	callback, _ := startOIDCLogin(t, s, "alice")
+
+	if rec := oidcCallback(s, callback, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	other := &http.Cookie{Name: oidcStateCookie, Value: "other-state"}
+	if rec := oidcCallback(s, callback, other); rec.Code != http.StatusBadRequest {
+		t.Fatalf("other state: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if len(store.requests) != 1 {
+		t.Errorf("requests = %d, want 1（Cookie が一致しない場合は消費しない）", len(store.requests))
+	}
+}
+
+func TestOIDCCallbackRejectsWrongCodeVerifier(t *testing.T) {
+	s, store := newOIDCTestServer(t)
+	callback, cookie := startOIDCLogin(t, s, "alice")
+
+	req := store.requests[cookie.Value]
+	store.requests[cookie.Value] = [2]string{req[0], req[1] + "x"}
+
+	if rec := oidcCallback(s, callback, cookie); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if len(store.users) != 0 {
+		t.Errorf("users = %v, want none", store.users)
+	}
+}
+
+func TestOIDCCallbackRejectsWrongNonce(t *testing.T) {
+	s, store := newOIDCTestServer(t)
+	callback, cookie := startOIDCLogin(t, s, "alice")
+
+	req := store.requests[cookie.Value]
+	store.requests[cookie.Value] = [2]string{"other-nonce", req[1]}
+
+	if rec := oidcCallback(s, callback, cookie); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if len(store.users) != 0 {
+		t.Errorf("users = %v, want none", store.users)
+	}
+}
@@ -6,75 +6,70 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"sort"
+	"time"
 )
 
 type RoomRequest struct {
-	User1 string `json:"user1"` // 現在のユーザー
-	User2 string `json:"user2"` // 対象ユーザー
+	UserID   int    `json:"user_id"`  // 相手のユーザーID
+	Username string `json:"username"` // 相手のユーザー名（user_id がない場合）
 }
 
 type RoomResponse struct {
 	RoomID int `json:"room_id"`
 }
 
-// POST /oneroom 2人の間に1対1の部屋を取得または作成
+// 相手ユーザーのオンライン状態
+type Presence struct {
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// GET /oneroom の各要素（ルーム名の代わりに相手の情報を返す）
+type DirectRoomItem struct {
+	RoomInfo
+	UserID   int      `json:"user_id"`
+	Username string   `json:"username"`
+	Presence Presence `json:"presence"`
+}
+
+// POST /get-or-create-room 自分と相手の1対1の部屋を取得または作成
+// 自分はトークンから取得する（リクエストで他人を指定させない）
 func (s *Server) GetOrCreateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+
 	var req RoomRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "リクエストボディの形式が無効です", http.StatusBadRequest) // 请求体格式错误
 		return
 	}
 
-	// 2人のユーザーIDを取得
-	var userIDs [2]int
-	err := s.DB.QueryRow(`SELECT id FROM users WHERE username=$1`, req.User1).Scan(&userIDs[0])
-	if err != nil {
-		http.Error(w, "ユーザー1が見つかりません", http.StatusBadRequest) // 找不到用户1
+	// 相手のユーザーIDを取得
+	var targetID int
+	if req.UserID != 0 {
+		err = s.DB.QueryRow(`SELECT id FROM users WHERE id = $1`, req.UserID).Scan(&targetID)
+	} else {
+		err = s.DB.QueryRow(`SELECT id FROM users WHERE username = $1`, req.Username).Scan(&targetID)
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "相手のユーザーが見つかりません", http.StatusBadRequest) // 找不到用户
+		return
+	} else if err != nil {
+		http.Error(w, "ユーザーの取得に失敗しました", http.StatusInternalServerError)
 		return
 	}
-	err = s.DB.QueryRow(`SELECT id FROM users WHERE username=$1`, req.User2).Scan(&userIDs[1])
-	if err != nil {
-		http.Error(w, "ユーザー2が見つかりません", http.StatusBadRequest) // 找不到用户2
+	if targetID == userID {
+		http.Error(w, "自分自身とのルームは作成できません", http.StatusBadRequest)
 		return
 	}
-	sort.Ints(userIDs[:]) // 一意性を保つため順番を固定する
-
-	// 一意なルーム名を生成（ユーザー名を結合）
-	roomName := req.User1 + "_" + req.User2
 
-	// すでに2人が参加していて is_group = false の部屋が存在するか確認
-	var roomID int
-	query := `
-		SELECT cr.id FROM chat_rooms cr
-		JOIN room_members rm1 ON cr.id = rm1.room_id AND rm1.user_id = $1
-		JOIN room_members rm2 ON cr.id = rm2.room_id AND rm2.user_id = $2
-		WHERE cr.is_group = false
-		LIMIT 1;
-	`
-	err = s.DB.QueryRow(query, userIDs[0], userIDs[1]).Scan(&roomID)
-
-	if err == sql.ErrNoRows {
-		// 新しい部屋を作成
-		err = s.DB.QueryRow(
-			`INSERT INTO chat_rooms (is_group, room_name) VALUES (false, $1) RETURNING id`,
-			roomName,
-		).Scan(&roomID)
-		if err != nil {
-			http.Error(w, "ルームの作成に失敗しました", http.StatusInternalServerError) // 创建房间失败
-			return
-		}
-		// 両方のユーザーを room_members に追加
-		_, err = s.DB.Exec(
-			`INSERT INTO room_members (room_id, user_id) VALUES ($1, $2), ($1, $3)`,
-			roomID, userIDs[0], userIDs[1],
-		)
-		if err != nil {
-			http.Error(w, "ルームメンバーの追加に失敗しました", http.StatusInternalServerError) // 添加房间成员失败
-			return
-		}
-	} else if err != nil {
-		http.Error(w, "ルームの検索に失敗しました", http.StatusInternalServerError) // 查询房间失败
+	// ✅ 2人の組み合わせで一意（ルーム名は持たない）
+	roomID, _, err := s.findOrCreateDirectRoom(userID, []int{userID, targetID})
+	if err != nil {
+		http.Error(w, "ルームの作成に失敗しました", http.StatusInternalServerError) // 创建房间失败
 		return
 	}
 
@@ -90,12 +85,15 @@ func (s *Server) GetUserOneroomHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// is_group = false の一対一チャットルームを取得
+	// is_group = false の一対一チャットルームと相手ユーザーを取得
 	rows, err := s.DB.Query(`
-		SELECT cr.id, cr.room_name, cr.is_group
+		SELECT `+roomInfoColumns+`, u.id, u.username, u.last_seen_at
 		FROM chat_rooms cr
-		JOIN room_members rm ON cr.id = rm.room_id
-		WHERE rm.user_id = $1 AND cr.is_group = false
+		JOIN room_members rm ON cr.id = rm.room_id AND rm.user_id = $1
+		JOIN room_members other ON other.room_id = cr.id AND other.user_id <> $1
+		JOIN users u ON u.id = other.user_id
+		WHERE cr.is_group = false
+		ORDER BY cr.id
 	`, userID)
 	if err != nil {
 		log.Println("❌ ルームの取得に失敗:", err)                               // 查詢房間失敗
@@ -104,12 +102,18 @@ func (s *Server) GetUserOneroomHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var rooms []RoomInfo
+	rooms := []DirectRoomItem{}
 	for rows.Next() {
-		var room RoomInfo
-		if err := rows.Scan(&room.ID, &room.RoomName, &room.IsGroup); err == nil {
-			rooms = append(rooms, room)
+		var room DirectRoomItem
+		var lastSeen sql.NullTime
+		if err := scanRoomInfo(rows, &room.RoomInfo, &room.UserID, &room.Username, &lastSeen); err != nil {
+			continue
+		}
+		room.Presence.Online = s.WSHub.IsOnline(room.UserID)
+		if lastSeen.Valid {
+			room.Presence.LastSeenAt = &lastSeen.Time
 		}
+		rooms = append(rooms, room)
 	}
 
 	// 一対一チャットルームのリストを返す
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"backend/mail"
+	"backend/utils"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// パスワードリセットトークンの有効期限
+const passwordResetTTL = 30 * time.Minute
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	Username string `json:"username"` // username または email のどちらかを指定
+	Email    string `json:"email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type ChangeEmailRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Email           string `json:"email"` // 空文字で削除
+}
+
+// POST /me/password
+// 現在のパスワードを確認してから変更し、他のセッションをすべて失効させる
+func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetClaimsFromRequest(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "無効なリクエストです", http.StatusBadRequest)
+		return
+	}
+
+	var username, storedHash string
+	err = s.DB.QueryRow("SELECT username, password_hash FROM users WHERE id = $1", claims.UserID).Scan(&username, &storedHash)
+	if err != nil {
+		http.Error(w, "ユーザー情報の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(req.CurrentPassword)); err != nil {
+		http.Error(w, "現在のパスワードが間違っています", http.StatusForbidden)
+		return
+	}
+
+	if err := s.PasswordPolicy.Validate(req.NewPassword, username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "パスワードの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err := setPassword(tx, claims.UserID, req.NewPassword); err != nil || tx.Commit() != nil {
+		http.Error(w, "パスワードの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// ✅ 現在のセッション以外を失効させる（他端末はログアウト扱い）
+	if err := utils.RevokeUserSessions(s.DB, claims.UserID, claims.ID); err != nil {
+		log.Println("❌ セッション失効に失敗:", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "パスワードを変更しました", // 密碼已變更
+	})
+}
+
+// PUT /me/email
+// 現在のパスワードを確認してからメールアドレス（パスワードリセットの送信先）を変更する
+// 以前のアドレス宛てに発行済みのリセットトークンは無効化する
+func (s *Server) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "無効なリクエストです", http.StatusBadRequest)
+		return
+	}
+	var email sql.NullString
+	if strings.TrimSpace(req.Email) != "" {
+		addr, err := normalizeEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		email = sql.NullString{String: addr, Valid: true}
+	}
+
+	var storedHash string
+	if err := s.DB.QueryRow("SELECT password_hash FROM users WHERE id = $1", userID).Scan(&storedHash); err != nil {
+		http.Error(w, "ユーザー情報の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	// パスワード未設定（SSO のみ）のアカウントはプロバイダーのメールアドレスを使う
+	if storedHash == "" {
+		http.Error(w, "パスワードが設定されていないアカウントではメールアドレスを変更できません", http.StatusForbidden)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(req.CurrentPassword)); err != nil {
+		http.Error(w, "現在のパスワードが間違っています", http.StatusForbidden)
+		return
+	}
+
+	if email.Valid {
+		var emailTaken bool
+		err = s.DB.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1) AND id <> $2)
+		`, email.String, userID).Scan(&emailTaken)
+		if err != nil {
+			http.Error(w, "データベース照会に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		if emailTaken {
+			http.Error(w, "このメールアドレスは既に使用されています", http.StatusConflict)
+			return
+		}
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "メールアドレスの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	_, err1 := tx.Exec(`UPDATE users SET email = $2 WHERE id = $1`, userID, email)
+	_, err2 := tx.Exec(`
+		UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL
+	`, userID)
+	if err1 != nil || err2 != nil || tx.Commit() != nil {
+		http.Error(w, "メールアドレスの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "メールアドレスを変更しました",
+		"email":   email.String,
+	})
+}
+
+// POST /password-reset/request
+// リセット用トークンを発行してメール送信する
+// ユーザーの存在有無に関わらず同じレスポンスを返す（アカウント列挙対策）
+// メール送信が設定されていない場合は 503 を返す
+func (s *Server) RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Mailer == nil {
+		http.Error(w, "パスワードリセットは現在利用できません", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "無効なリクエストです", http.StatusBadRequest)
+		return
+	}
+
+	respond := func() {
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "登録済みのメールアドレスにリセット手順を送信しました",
+		})
+	}
+
+	var userID int
+	var email sql.NullString
+	err := s.DB.QueryRow(`
+		SELECT id, email FROM users
+		WHERE ($1 <> '' AND username = $1) OR ($2 <> '' AND lower(email) = lower($2))
+		LIMIT 1
+	`, strings.TrimSpace(req.Username), strings.TrimSpace(req.Email)).Scan(&userID, &email)
+	if err == sql.ErrNoRows || (err == nil && !email.Valid) {
+		respond()
+		return
+	} else if err != nil {
+		http.Error(w, "データベース照会に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := utils.NewRandomToken(32)
+	if err != nil {
+		http.Error(w, "トークンの生成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = s.DB.Exec(`
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`, userID, utils.HashToken(token), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		http.Error(w, "トークンの保存に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	msg := mail.Message{
+		To:      email.String,
+		Subject: "パスワードリセットのご案内",
+		Body: "以下のリンクからパスワードを再設定してください（" +
+			passwordResetTTL.String() + " 以内・1回のみ有効）。\n\n" +
+			utils.AppBaseURL() + "/reset-password?token=" + token + "\n\n" +
+			"心当たりがない場合はこのメールを無視してください。\n",
+	}
+	if err := s.Mailer.Send(context.Background(), msg); err != nil {
+		log.Println("❌ リセットメールの送信に失敗:", err)
+	}
+
+	respond()
+}
+
+// POST /password-reset/confirm
+// トークンを検証して新しいパスワードを設定する（トークンは一回限り）
+func (s *Server) ConfirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "無効なリクエストです", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "パスワードの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// ✅ トークンの消費とパスワードの更新を同じトランザクションで行う
+	// （同時リクエストでも一度しか成功せず、途中で失敗した場合はトークンも消費されない）
+	var userID int
+	var username string
+	err = tx.QueryRow(`
+		UPDATE password_reset_tokens t SET used_at = NOW()
+		FROM users u
+		WHERE u.id = t.user_id
+		  AND t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > NOW()
+		RETURNING t.user_id, u.username
+	`, utils.HashToken(req.Token)).Scan(&userID, &username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "リセットリンクが無効または期限切れです", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "トークンの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.PasswordPolicy.Validate(req.NewPassword, username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := setPassword(tx, userID, req.NewPassword); err != nil {
+		http.Error(w, "パスワードの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "パスワードの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// ✅ リセット時は全セッションを失効させる
+	if err := utils.RevokeUserSessions(s.DB, userID, ""); err != nil {
+		log.Println("❌ セッション失効に失敗:", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "パスワードを再設定しました。再度ログインしてください",
+	})
+}
+
+// パスワードをハッシュ化して保存し、未使用のリセットトークンを無効化する
+func setPassword(tx *sql.Tx, userID int, password string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE users SET password_hash = $1, password_changed_at = NOW() WHERE id = $2
+	`, string(hashed), userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL
+	`, userID)
+	return err
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 1ルームあたりのピン留め上限
+const maxPinnedMessages = 50
+
+type PinnedMessage struct {
+	MessageID     int       `json:"message_id"`
+	RoomID        int       `json:"room_id"`
+	SenderID      int       `json:"sender_id"`
+	Sender        string    `json:"sender"`
+	Preview       string    `json:"preview"`
+	HasAttachment bool      `json:"has_attachment"`
+	CreatedAt     time.Time `json:"created_at"`
+	PinnedBy      *int      `json:"pinned_by,omitempty"`
+	PinnedByName  string    `json:"pinned_by_name,omitempty"`
+	PinnedAt      time.Time `json:"pinned_at"`
+}
+
+// ピン留め対象のメッセージ（URL の message_id）を取得し、権限を確認する
+func (s *Server) pinTarget(w http.ResponseWriter, r *http.Request) (messageID, roomID, userID int, ok bool) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインが必要です", http.StatusUnauthorized)
+		return 0, 0, 0, false
+	}
+	messageID, err = strconv.Atoi(mux.Vars(r)["message_id"])
+	if err != nil {
+		http.Error(w, "メッセージIDが無効です", http.StatusBadRequest)
+		return 0, 0, 0, false
+	}
+
+	var content string
+	err = s.DB.QueryRow(`SELECT room_id, content FROM messages WHERE id = $1`, messageID).Scan(&roomID, &content)
+	if err == sql.ErrNoRows {
+		http.Error(w, "メッセージが存在しません", http.StatusNotFound)
+		return 0, 0, 0, false
+	} else if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return 0, 0, 0, false
+	}
+	if strings.HasPrefix(content, "reaction:") {
+		http.Error(w, "リアクションはピン留めできません", http.StatusBadRequest)
+		return 0, 0, 0, false
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermPinMessage); !ok {
+		return 0, 0, 0, false
+	}
+	return messageID, roomID, userID, true
+}
+
+// ピン留め情報を取得（一覧・イベント共通）
+func (s *Server) pinnedMessages(where string, args ...any) ([]PinnedMessage, error) {
+	rows, err := s.DB.Query(`
+		SELECT m.id, m.room_id, m.sender_id, u.username, m.content,
+			EXISTS (SELECT 1 FROM message_attachments a WHERE a.message_id = m.id),
+			m.created_at, p.pinned_by, COALESCE(pu.username, ''), p.pinned_at
+		FROM message_pins p
+		JOIN messages m ON m.id = p.message_id
+		JOIN users u ON u.id = m.sender_id
+		LEFT JOIN users pu ON pu.id = p.pinned_by
+		WHERE `+where+`
+		ORDER BY p.pinned_at DESC, m.id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []PinnedMessage{}
+	for rows.Next() {
+		var p PinnedMessage
+		var content string
+		var pinnedBy sql.NullInt64
+		if err := rows.Scan(&p.MessageID, &p.RoomID, &p.SenderID, &p.Sender, &content,
+			&p.HasAttachment, &p.CreatedAt, &pinnedBy, &p.PinnedByName, &p.PinnedAt); err != nil {
+			return nil, err
+		}
+		p.Preview = messagePreview(content, p.HasAttachment)
+		if pinnedBy.Valid {
+			id := int(pinnedBy.Int64)
+			p.PinnedBy = &id
+		}
+		pins = append(pins, p)
+	}
+	return pins, rows.Err()
+}
+
+// POST /messages/{message_id}/pin メッセージをピン留め（member 以上）
+func (s *Server) PinMessageHandler(w http.ResponseWriter, r *http.Request) {
+	messageID, roomID, userID, ok := s.pinTarget(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// ✅ 同時にピン留めされても上限を超えないようにルームをロック
+	if _, err := tx.Exec(`SELECT id FROM chat_rooms WHERE id = $1 FOR UPDATE`, roomID); err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	var count int
+	var already bool
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(BOOL_OR(message_id = $2), false)
+		FROM message_pins WHERE room_id = $1
+	`, roomID, messageID).Scan(&count, &already)
+	if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	if already {
+		http.Error(w, "すでにピン留めされています", http.StatusConflict)
+		return
+	}
+	if count >= maxPinnedMessages {
+		http.Error(w, fmt.Sprintf("ピン留めできるのは1ルームにつき %d 件までです", maxPinnedMessages), http.StatusConflict)
+		return
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO message_pins (message_id, room_id, pinned_by) VALUES ($1, $2, $3)
+	`, messageID, roomID, userID); err != nil {
+		http.Error(w, "ピン留めに失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "ピン留めに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	pins, err := s.pinnedMessages(`p.message_id = $1`, messageID)
+	if err != nil || len(pins) == 0 {
+		http.Error(w, "ピン留め情報の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	s.WSHub.Broadcast <- WSMessage{
+		RoomID: roomID,
+		Data: map[string]any{
+			"type":    "message_pinned",
+			"room_id": roomID,
+			"pin":     pins[0],
+		},
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(pins[0])
+}
+
+// POST /messages/{message_id}/unpin ピン留めを解除（member 以上）
+func (s *Server) UnpinMessageHandler(w http.ResponseWriter, r *http.Request) {
+	messageID, roomID, userID, ok := s.pinTarget(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.DB.Exec(`DELETE FROM message_pins WHERE message_id = $1`, messageID)
+	if err != nil {
+		http.Error(w, "ピン留めの解除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "ピン留めされていません", http.StatusNotFound)
+		return
+	}
+
+	s.WSHub.Broadcast <- WSMessage{
+		RoomID: roomID,
+		Data: map[string]any{
+			"type":       "message_unpinned",
+			"room_id":    roomID,
+			"message_id": messageID,
+			"by":         userID,
+		},
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "ピン留めを解除しました",
+	})
+}
+
+// GET /rooms/{room_id}/pins ルームのピン留めメッセージ一覧（新しい順）
+func (s *Server) GetRoomPinsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインが必要です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := roomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
+
+	pins, err := s.pinnedMessages(`p.room_id = $1`, roomID)
+	if err != nil {
+		http.Error(w, "ピン留めの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"room_id": roomID,
+		"pins":    pins,
+		"limit":   maxPinnedMessages,
+	})
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// 添付ファイルの保存容量（ユーザーごと・ルームごと）
+// 重複排除で blob を共有していても、メッセージごとのサイズで数える
+
+// ルームごとの使用量（自分の分とルーム全体）
+type RoomStorageUsage struct {
+	RoomID        int    `json:"room_id"`
+	RoomName      string `json:"room_name"`
+	UsedBytes     int64  `json:"used_bytes"`
+	RoomUsedBytes int64  `json:"room_used_bytes"`
+	RoomQuota     int64  `json:"room_quota_bytes"` // 0 は無制限
+}
+
+type StorageUsage struct {
+	UsedBytes   int64              `json:"used_bytes"`
+	QuotaBytes  int64              `json:"quota_bytes"` // 0 は無制限
+	Attachments int                `json:"attachments"`
+	Rooms       []RoomStorageUsage `json:"rooms"`
+}
+
+// *sql.DB と *sql.Tx の両方で使えるように
+type sqlQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// ユーザーが送信した添付ファイルの合計サイズ
+func userStorageUsed(db sqlQueryer, userID int) (int64, error) {
+	var used int64
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(a.size_bytes), 0) FROM message_attachments a
+		JOIN messages m ON m.id = a.message_id
+		WHERE m.sender_id = $1
+	`, userID).Scan(&used)
+	return used, err
+}
+
+// ルームの添付ファイルの合計サイズ
+func roomStorageUsed(db sqlQueryer, roomID int) (int64, error) {
+	var used int64
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(a.size_bytes), 0) FROM message_attachments a
+		JOIN messages m ON m.id = a.message_id
+		WHERE m.room_id = $1
+	`, roomID).Scan(&used)
+	return used, err
+}
+
+// ✅ incoming バイトを追加してもユーザー・ルームの上限を超えないか確認する
+// ファイルを読み込む前の事前確認用。確定は登録時の lockStorageQuota で行う
+func (s *Server) checkStorageQuota(userID, roomID int, incoming int64) error {
+	return s.checkStorageQuotaOn(s.DB, userID, roomID, incoming)
+}
+
+func (s *Server) checkStorageQuotaOn(db sqlQueryer, userID, roomID int, incoming int64) error {
+	if quota := s.AttachmentPolicy.UserQuota; quota > 0 {
+		used, err := userStorageUsed(db, userID)
+		if err != nil {
+			return err
+		}
+		if used+incoming > quota {
+			return &uploadError{http.StatusInsufficientStorage, fmt.Sprintf("保存容量の上限（%d MB）を超えています", quota>>20)}
+		}
+	}
+	if quota := s.AttachmentPolicy.RoomQuota; quota > 0 {
+		used, err := roomStorageUsed(db, roomID)
+		if err != nil {
+			return err
+		}
+		if used+incoming > quota {
+			return &uploadError{http.StatusInsufficientStorage, fmt.Sprintf("このルームの保存容量の上限（%d MB）を超えています", quota>>20)}
+		}
+	}
+	return nil
+}
+
+// ✅ 添付ファイルを登録するトランザクション内で、ユーザーとルームの行をロックしてから上限を確認する
+// 同じユーザー・ルームへの同時アップロードが両方とも確認を通って上限を超えることを防ぐ
+// （FOR NO KEY UPDATE なので、メッセージ挿入の外部キー確認はブロックしない）
+func (s *Server) lockStorageQuota(tx *sql.Tx, userID, roomID int, incoming int64) error {
+	if s.AttachmentPolicy.UserQuota > 0 {
+		if _, err := tx.Exec(`SELECT 1 FROM users WHERE id = $1 FOR NO KEY UPDATE`, userID); err != nil {
+			return err
+		}
+	}
+	if s.AttachmentPolicy.RoomQuota > 0 {
+		if _, err := tx.Exec(`SELECT 1 FROM chat_rooms WHERE id = $1 FOR NO KEY UPDATE`, roomID); err != nil {
+			return err
+		}
+	}
+	return s.checkStorageQuotaOn(tx, userID, roomID, incoming)
+}
+
+// GET /me/storage 自分の添付ファイルの使用量
+func (s *Server) GetMyStorageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized)
+		return
+	}
+
+	usage := StorageUsage{QuotaBytes: s.AttachmentPolicy.UserQuota, Rooms: []RoomStorageUsage{}}
+
+	// 自分が添付ファイルを送ったルームごとに、自分の分とルーム全体の使用量を集計
+	rows, err := s.DB.Query(`
+		WITH mine AS (
+			SELECT m.room_id, COALESCE(SUM(a.size_bytes), 0) AS used, COUNT(*) AS n
+			FROM message_attachments a
+			JOIN messages m ON m.id = a.message_id
+			WHERE m.sender_id = $1
+			GROUP BY m.room_id
+		)
+		SELECT mine.room_id, cr.room_name, mine.used, mine.n,
+			(SELECT COALESCE(SUM(a2.size_bytes), 0) FROM message_attachments a2
+			 JOIN messages m2 ON m2.id = a2.message_id
+			 WHERE m2.room_id = mine.room_id)
+		FROM mine
+		JOIN chat_rooms cr ON cr.id = mine.room_id
+		ORDER BY mine.used DESC
+	`, userID)
+	if err != nil {
+		log.Println("❌ 使用量の取得に失敗:", err)
+		http.Error(w, "使用量の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room RoomStorageUsage
+		var n int
+		if err := rows.Scan(&room.RoomID, &room.RoomName, &room.UsedBytes, &n, &room.RoomUsedBytes); err != nil {
+			http.Error(w, "使用量の取得に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		room.RoomQuota = s.AttachmentPolicy.RoomQuota
+		usage.UsedBytes += room.UsedBytes
+		usage.Attachments += n
+		usage.Rooms = append(usage.Rooms, room)
+	}
+
+	json.NewEncoder(w).Encode(usage)
+}
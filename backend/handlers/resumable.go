@@ -0,0 +1,521 @@
+package handlers
+
+import (
+	"backend/storage"
+	"backend/utils"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 再開可能なアップロード（tus 1.0.0 の core / creation / termination 互換）
+//
+//	POST   /resumable-uploads                 作成（Upload-Length, Upload-Metadata: filename, room_id）
+//	HEAD   /resumable-uploads/{id}            現在の Upload-Offset を取得（再開時）
+//	PATCH  /resumable-uploads/{id}            チャンクを送信（Upload-Offset は現在のオフセットと一致させる）
+//	DELETE /resumable-uploads/{id}            中止
+//	POST   /resumable-uploads/{id}/complete   完了したファイルをメッセージとして投稿
+//
+// チャンクは受け取るたびにストレージへ個別に保存し、完了時に連結して添付ファイルにする
+const (
+	tusVersion           = "1.0.0"
+	uploadSessionTTL     = 24 * time.Hour
+	maxUploadChunkSize   = 16 << 20 // 1回の PATCH で送れる最大サイズ
+	maxActiveUploads     = 20       // ユーザーごとの同時アップロード数
+	uploadChunkMediaType = "application/offset+octet-stream"
+)
+
+type uploadSession struct {
+	ID          string
+	UserID      int
+	RoomID      int
+	FileName    string
+	Length      int64
+	Offset      int64
+	ContentType *string
+	Completing  bool
+	ExpiresAt   time.Time
+}
+
+// 完了時に投稿するメッセージの内容
+type CompleteUploadRequest struct {
+	Content      string   `json:"content"`
+	ThreadRootID *int     `json:"thread_root_id"`
+	Mentions     []string `json:"mentions"`
+}
+
+// Upload-Metadata ヘッダー（"key base64値,key base64値"）を解析
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+func setUploadHeaders(w http.ResponseWriter, sess *uploadSession) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(sess.Length, 10))
+	w.Header().Set("Upload-Expires", sess.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// POST /resumable-uploads アップロードを作成
+func (s *Server) CreateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length が無効です", http.StatusBadRequest)
+		return
+	}
+	if length > s.AttachmentPolicy.MaxUploadSize() {
+		http.Error(w, fmt.Sprintf("ファイルサイズが上限（%d MB）を超えています", s.AttachmentPolicy.MaxUploadSize()>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Upload-Metadata が無効です", http.StatusBadRequest)
+		return
+	}
+	roomID, err := strconv.Atoi(meta["room_id"])
+	if err != nil {
+		http.Error(w, "無効な room_id", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermPostMessage); !ok {
+		return
+	}
+
+	if err := s.checkStorageQuota(userID, roomID, length); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	var active int
+	err = s.DB.QueryRow(`SELECT COUNT(*) FROM upload_sessions WHERE user_id = $1 AND expires_at > NOW()`, userID).Scan(&active)
+	if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	if active >= maxActiveUploads {
+		http.Error(w, "進行中のアップロードが多すぎます", http.StatusTooManyRequests)
+		return
+	}
+
+	id, err := utils.NewRandomToken(16)
+	if err != nil {
+		http.Error(w, "アップロードの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	sess := &uploadSession{
+		ID:        id,
+		UserID:    userID,
+		RoomID:    roomID,
+		FileName:  utils.SanitizeFileName(meta["filename"]),
+		Length:    length,
+		ExpiresAt: time.Now().Add(uploadSessionTTL),
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO upload_sessions (id, user_id, room_id, file_name, upload_length, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, sess.ID, sess.UserID, sess.RoomID, sess.FileName, sess.Length, sess.ExpiresAt)
+	if err != nil {
+		log.Println("❌ アップロードの作成に失敗:", err)
+		http.Error(w, "アップロードの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	location := "/resumable-uploads/" + sess.ID
+	setUploadHeaders(w, sess)
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":         sess.ID,
+		"upload_url": location,
+		"expires_at": sess.ExpiresAt,
+	})
+}
+
+// パスの {upload_id} から自分のアップロードを取得（期限切れは 410）
+func (s *Server) uploadSessionFromPath(w http.ResponseWriter, r *http.Request) (*uploadSession, bool) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	sess := &uploadSession{}
+	err = s.DB.QueryRow(`
+		SELECT id, user_id, room_id, file_name, upload_length, upload_offset, content_type, completing, expires_at
+		FROM upload_sessions
+		WHERE id = $1 AND user_id = $2
+	`, mux.Vars(r)["upload_id"], userID).Scan(
+		&sess.ID, &sess.UserID, &sess.RoomID, &sess.FileName, &sess.Length, &sess.Offset,
+		&sess.ContentType, &sess.Completing, &sess.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		http.Error(w, "アップロードが見つかりません", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return nil, false
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		http.Error(w, "アップロードの有効期限が切れています", http.StatusGone)
+		return nil, false
+	}
+	return sess, true
+}
+
+// HEAD /resumable-uploads/{upload_id} 受信済みのオフセットを返す
+func (s *Server) GetUploadOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.uploadSessionFromPath(w, r)
+	if !ok {
+		return
+	}
+	setUploadHeaders(w, sess)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PATCH /resumable-uploads/{upload_id} チャンクを受信してストレージに保存
+func (s *Server) PatchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.uploadSessionFromPath(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != uploadChunkMediaType {
+		http.Error(w, "Content-Type は "+uploadChunkMediaType+" にしてください", http.StatusUnsupportedMediaType)
+		return
+	}
+	if sess.Completing {
+		http.Error(w, "アップロードは完了処理中です", http.StatusConflict)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != sess.Offset {
+		// 再送や並行送信でずれた場合は現在のオフセットを返して再開させる
+		setUploadHeaders(w, sess)
+		http.Error(w, "Upload-Offset が一致しません", http.StatusConflict)
+		return
+	}
+	if r.ContentLength < 0 {
+		http.Error(w, "Content-Length が必要です", http.StatusLengthRequired)
+		return
+	}
+	if r.ContentLength > maxUploadChunkSize {
+		http.Error(w, fmt.Sprintf("チャンクは %d MB までです", maxUploadChunkSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if offset+r.ContentLength > sess.Length {
+		http.Error(w, "Upload-Length を超えています", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength == 0 {
+		setUploadHeaders(w, sess)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body := bufio.NewReaderSize(http.MaxBytesReader(w, r.Body, r.ContentLength), 512)
+
+	// 最初のチャンクで形式を確認し、許可されない形式は全体を受け取る前に断る
+	var contentType *string
+	if offset == 0 {
+		head, _ := body.Peek(512)
+		ct, err := s.checkAttachment(head, sess.Length)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		contentType = &ct
+	}
+
+	suffix, err := utils.NewRandomToken(6)
+	if err != nil {
+		http.Error(w, "チャンクの保存に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	key := fmt.Sprintf("uploads/%s/%016d-%s", sess.ID, offset, suffix)
+	if err := s.Storage.Put(r.Context(), key, body, r.ContentLength, "application/octet-stream"); err != nil {
+		// 接続が切れた場合もここに来る。オフセットは進めないので同じ位置から再開できる
+		log.Println("⚠️ チャンクの保存に失敗:", err)
+		http.Error(w, "チャンクの保存に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	newOffset := offset + r.ContentLength
+	if err := s.commitUploadChunk(sess, offset, newOffset, key, contentType); err != nil {
+		_ = s.Storage.Delete(context.Background(), key)
+		if errors.Is(err, errUploadOffsetMoved) {
+			http.Error(w, "Upload-Offset が一致しません", http.StatusConflict)
+			return
+		}
+		log.Println("❌ チャンクの記録に失敗:", err)
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+
+	sess.Offset = newOffset
+	setUploadHeaders(w, sess)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 並行する PATCH で先にオフセットが進んでいた
+var errUploadOffsetMoved = errors.New("upload offset moved")
+
+// チャンクを記録してオフセットを進める（オフセットが変わっていなければ）
+func (s *Server) commitUploadChunk(sess *uploadSession, offset, newOffset int64, key string, contentType *string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE upload_sessions
+		SET upload_offset = $3, content_type = COALESCE(content_type, $4)
+		WHERE id = $1 AND upload_offset = $2 AND NOT completing AND expires_at > NOW()
+	`, sess.ID, offset, newOffset, contentType)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errUploadOffsetMoved
+	}
+	_, err = tx.Exec(`
+		INSERT INTO upload_chunks (upload_id, chunk_offset, size, storage_key)
+		VALUES ($1, $2, $3, $4)
+	`, sess.ID, offset, newOffset-offset, key)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// アップロードのチャンクのキー（オフセット順）
+func (s *Server) uploadChunkKeys(uploadID string) ([]string, error) {
+	rows, err := s.DB.Query(`
+		SELECT storage_key FROM upload_chunks WHERE upload_id = $1 ORDER BY chunk_offset ASC
+	`, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// アップロードとチャンクを削除
+func (s *Server) deleteUploadSession(ctx context.Context, uploadID string) error {
+	keys, err := s.uploadChunkKeys(uploadID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.DB.Exec(`DELETE FROM upload_sessions WHERE id = $1`, uploadID); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.Storage.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Println("⚠️ チャンクの削除に失敗:", key, err)
+		}
+	}
+	return nil
+}
+
+// DELETE /resumable-uploads/{upload_id} アップロードを中止
+func (s *Server) DeleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.uploadSessionFromPath(w, r)
+	if !ok {
+		return
+	}
+	if sess.Completing {
+		http.Error(w, "アップロードは完了処理中です", http.StatusConflict)
+		return
+	}
+	if err := s.deleteUploadSession(r.Context(), sess.ID); err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /resumable-uploads/{upload_id}/complete 受信済みのファイルを添付したメッセージを投稿
+func (s *Server) CompleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.uploadSessionFromPath(w, r)
+	if !ok {
+		return
+	}
+	if sess.Offset != sess.Length {
+		setUploadHeaders(w, sess)
+		http.Error(w, "アップロードが完了していません", http.StatusConflict)
+		return
+	}
+
+	// 本文は任意（ファイルだけの投稿も可）
+	var req CompleteUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "リクエスト形式が正しくありません", http.StatusBadRequest)
+		return
+	}
+
+	// 作成後にルームから外れていないか改めて確認
+	if _, ok := s.requireRoomPermission(w, r, sess.RoomID, sess.UserID, PermPostMessage); !ok {
+		return
+	}
+
+	// 作成後に他のアップロードで容量が埋まっていないか改めて確認
+	if err := s.checkStorageQuota(sess.UserID, sess.RoomID, sess.Length); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	// ✅ 二重に完了処理しないよう確保
+	res, err := s.DB.Exec(`UPDATE upload_sessions SET completing = TRUE WHERE id = $1 AND NOT completing`, sess.ID)
+	if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "アップロードは完了処理中です", http.StatusConflict)
+		return
+	}
+	release := func() {
+		_, _ = s.DB.Exec(`UPDATE upload_sessions SET completing = FALSE WHERE id = $1`, sess.ID)
+	}
+
+	keys, err := s.uploadChunkKeys(sess.ID)
+	if err != nil {
+		release()
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+
+	// チャンクを順に読みながら通常のアップロードと同じ検証・画像処理を行う
+	src := storage.NewConcatReader(r.Context(), s.Storage, keys)
+	att, err := s.prepareAttachmentFrom(sess.FileName, sess.Length, src)
+	src.Close()
+	if err != nil {
+		var ue *uploadError
+		if errors.As(err, &ue) {
+			// 形式やサイズの問題は再試行しても通らないので破棄する
+			_ = s.deleteUploadSession(r.Context(), sess.ID)
+		} else {
+			release()
+		}
+		writeUploadError(w, err)
+		return
+	}
+	defer att.close()
+
+	// ✅ 公開前にマルウェア検査（検出されたファイルは隔離済みなのでアップロードは破棄する）
+	atts := []*storedAttachment{att}
+	rejected, err := s.scanAttachments(r.Context(), sess.UserID, sess.RoomID, atts)
+	if err != nil {
+		release()
+	} else if len(rejected) > 0 {
+		_ = s.deleteUploadSession(r.Context(), sess.ID)
+	}
+	if !writeScanResult(w, rejected, err) {
+		return
+	}
+
+	now := time.Now()
+	messageID, err := s.insertMessageWithAttachments(r.Context(), sess.RoomID, sess.UserID, req.Content, req.ThreadRootID, atts, now)
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		// 容量を空ければ再試行できるようにアップロードは残す
+		release()
+		writeUploadError(w, err)
+		return
+	} else if err != nil {
+		log.Println("❌ 添付メッセージの書き込みに失敗:", err)
+		release()
+		http.Error(w, "メッセージ書き込みに失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if err := s.deleteUploadSession(r.Context(), sess.ID); err != nil {
+		log.Println("⚠️ アップロードの削除に失敗:", err)
+	}
+
+	attachments := s.publishAttachmentMessage(sess.RoomID, sess.UserID, messageID, req.Content, req.ThreadRootID, req.Mentions, atts, now)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":     "アップロード成功",
+		"id":          messageID,
+		"attachments": attachments,
+	})
+}
+
+// ✅ 期限切れのアップロードとチャンクを定期的に削除
+func (s *Server) RunUploadSessionCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.deleteExpiredUploadSessions()
+	}
+}
+
+func (s *Server) deleteExpiredUploadSessions() {
+	// 完了処理中のものは処理が終わるまで待つ（異常終了した場合に備えて期限から1日後には消す）
+	rows, err := s.DB.Query(`
+		SELECT id FROM upload_sessions
+		WHERE expires_at <= NOW() AND (NOT completing OR expires_at <= NOW() - INTERVAL '1 day')
+	`)
+	if err != nil {
+		log.Println("⚠️ 期限切れアップロードの取得に失敗:", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := s.deleteUploadSession(context.Background(), id); err != nil {
+			log.Println("⚠️ 期限切れアップロードの削除に失敗:", id, err)
+		}
+	}
+}
@@ -26,6 +26,9 @@ func (s *Server) EnterRoomHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "無効な room_id", http.StatusBadRequest) // 無效 room_id
 		return
 	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
 
 	// ユーザー名を取得
 	var username string
@@ -36,18 +39,16 @@ func (s *Server) EnterRoomHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ✅ このルーム内の未読メッセージをすべて既読としてマーク
+	// ✅ 既読位置をルームの最新メッセージまで進める（メッセージごとの行は作らない）
 	_, err = s.DB.Exec(`
-		INSERT INTO message_reads (user_id, message_id, read_at)
-		SELECT $1, m.id, NOW()
-		FROM messages m
-		WHERE m.room_id = $2
-		  AND m.sender_id != $1
-		  AND NOT EXISTS (
-			SELECT 1 FROM message_reads r
-			WHERE r.user_id = $1 AND r.message_id = m.id
-		  )
-		ON CONFLICT DO NOTHING
+		UPDATE room_members
+		SET last_read_message_id = GREATEST(
+				COALESCE(last_read_message_id, 0),
+				COALESCE((SELECT MAX(id) FROM messages WHERE room_id = $2), 0)
+			),
+			last_read_at = NOW(),
+			marked_unread = false
+		WHERE user_id = $1 AND room_id = $2
 	`, userID, roomID)
 	log.Printf("➡️ userID: %d が roomID: %d に入室しました\n", userID, roomID)
 	if err != nil {
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 自分だけに適用されるルーム設定（他のメンバーには影響しない）
+type RoomPreferences struct {
+	Muted      bool       `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"` // 未指定なら無期限
+	Pinned     bool       `json:"pinned"`
+	Archived   bool       `json:"archived"`
+}
+
+type UpdateRoomPreferencesRequest struct {
+	Muted      *bool      `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until"`
+	Pinned     *bool      `json:"pinned"`
+	Archived   *bool      `json:"archived"`
+}
+
+// ミュートが現在有効かどうか（期限切れは解除扱い）
+const roomMutedExpr = `(rm.muted AND (rm.muted_until IS NULL OR rm.muted_until > NOW()))`
+
+// アーカイブ後に新しいメッセージがなければアーカイブ中（新着があれば自動的に一覧へ戻る）
+const roomArchivedExpr = `(rm.archived_at IS NOT NULL AND NOT EXISTS (
+	SELECT 1 FROM messages am
+	WHERE am.room_id = rm.room_id AND am.created_at > rm.archived_at AND NOT am.content LIKE 'reaction:%'
+))`
+
+func (s *Server) roomPreferences(roomID, userID int) (RoomPreferences, error) {
+	var prefs RoomPreferences
+	var mutedUntil sql.NullTime
+	err := s.DB.QueryRow(`
+		SELECT `+roomMutedExpr+`, rm.muted_until, rm.pinned_at IS NOT NULL, `+roomArchivedExpr+`
+		FROM room_members rm
+		WHERE rm.room_id = $1 AND rm.user_id = $2
+	`, roomID, userID).Scan(&prefs.Muted, &mutedUntil, &prefs.Pinned, &prefs.Archived)
+	if prefs.Muted && mutedUntil.Valid {
+		prefs.MutedUntil = &mutedUntil.Time
+	}
+	return prefs, err
+}
+
+// ルームで現在ミュート中のメンバー
+func (s *Server) mutedRoomMembers(roomID int) map[int]bool {
+	muted := make(map[int]bool)
+	rows, err := s.DB.Query(`
+		SELECT rm.user_id FROM room_members rm
+		WHERE rm.room_id = $1 AND `+roomMutedExpr, roomID)
+	if err != nil {
+		return muted
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			muted[userID] = true
+		}
+	}
+	return muted
+}
+
+// 新着通知用の未読マップ（ミュート中のメンバーには通知しないので除外する）
+func (s *Server) notifyUnreadMapForRoom(roomID int) map[int]int {
+	unreadMap := s.GetUnreadMapForRoom(roomID)
+	for userID := range s.mutedRoomMembers(roomID) {
+		delete(unreadMap, userID)
+	}
+	return unreadMap
+}
+
+func roomIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	roomID, err := strconv.Atoi(mux.Vars(r)["room_id"])
+	if err != nil {
+		http.Error(w, "無効なルームID", http.StatusBadRequest) // 無效聊天室 ID
+		return 0, false
+	}
+	return roomID, true
+}
+
+// GET /rooms/{room_id}/preferences 自分のルーム設定を取得
+func (s *Server) GetRoomPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+	roomID, ok := roomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
+
+	prefs, err := s.roomPreferences(roomID, userID)
+	if err != nil {
+		http.Error(w, "ルーム設定の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(prefs)
+}
+
+// PATCH /rooms/{room_id}/preferences ミュート・ピン留め・アーカイブを部分更新
+func (s *Server) UpdateRoomPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+	roomID, ok := roomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
+
+	var req UpdateRoomPreferencesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "リクエスト形式が正しくありません", http.StatusBadRequest)
+		return
+	}
+	if req.MutedUntil != nil {
+		if !req.MutedUntil.After(time.Now()) {
+			http.Error(w, "ミュート期限には未来の日時を指定してください", http.StatusBadRequest)
+			return
+		}
+		// 期限だけ指定された場合はミュートとして扱う
+		if req.Muted == nil {
+			muted := true
+			req.Muted = &muted
+		}
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if req.Muted != nil {
+		var until any
+		if *req.Muted && req.MutedUntil != nil {
+			until = *req.MutedUntil
+		}
+		if _, err := tx.Exec(`
+			UPDATE room_members SET muted = $3, muted_until = $4
+			WHERE room_id = $1 AND user_id = $2
+		`, roomID, userID, *req.Muted, until); err != nil {
+			http.Error(w, "ルーム設定の更新に失敗しました", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.Pinned != nil {
+		// 既にピン留め済みなら日時は変えない（ピン留めした順を保つ）
+		if _, err := tx.Exec(`
+			UPDATE room_members
+			SET pinned_at = CASE WHEN $3 THEN COALESCE(pinned_at, NOW()) ELSE NULL END
+			WHERE room_id = $1 AND user_id = $2
+		`, roomID, userID, *req.Pinned); err != nil {
+			http.Error(w, "ルーム設定の更新に失敗しました", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.Archived != nil {
+		if _, err := tx.Exec(`
+			UPDATE room_members
+			SET archived_at = CASE WHEN $3 THEN NOW() ELSE NULL END
+			WHERE room_id = $1 AND user_id = $2
+		`, roomID, userID, *req.Archived); err != nil {
+			http.Error(w, "ルーム設定の更新に失敗しました", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "ルーム設定の更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	prefs, err := s.roomPreferences(roomID, userID)
+	if err != nil {
+		http.Error(w, "ルーム設定の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// ✅ 同じユーザーの他の端末に同期（本人の接続にだけ送る）
+	s.WSHub.SendToUser <- UserMessage{
+		UserID: userID,
+		Data: map[string]any{
+			"type":        "room_preferences_updated",
+			"room_id":     roomID,
+			"preferences": prefs,
+		},
+	}
+
+	json.NewEncoder(w).Encode(prefs)
+}
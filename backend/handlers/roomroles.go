@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ルーム内のロール
+type RoomRole string
+
+const (
+	RoleOwner    RoomRole = "owner"
+	RoleAdmin    RoomRole = "admin"
+	RoleMember   RoomRole = "member"
+	RoleReadOnly RoomRole = "readonly"
+)
+
+// ロールの強さ（大きいほど権限が強い）
+var roleRank = map[RoomRole]int{
+	RoleOwner:    4,
+	RoleAdmin:    3,
+	RoleMember:   2,
+	RoleReadOnly: 1,
+}
+
+func (r RoomRole) Valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// r が other より上位のロールか
+func (r RoomRole) Outranks(other RoomRole) bool {
+	return roleRank[r] > roleRank[other]
+}
+
+// ルーム内の操作権限
+type RoomPermission int
+
+const (
+	PermViewRoom      RoomPermission = iota // メッセージ閲覧・メンバー一覧
+	PermPostMessage                         // メッセージ送信・添付
+	PermPinMessage                          // メッセージのピン留め・解除
+	PermManageMembers                       // メンバー追加・キック・BAN・ロール変更
+	PermEditRoom                            // ルーム設定の変更
+	PermDeleteRoom                          // ルームの削除・オーナー譲渡
+)
+
+// 各権限に必要な最低ロール
+var permissionMinRole = map[RoomPermission]RoomRole{
+	PermViewRoom:      RoleReadOnly,
+	PermPostMessage:   RoleMember,
+	PermPinMessage:    RoleMember,
+	PermManageMembers: RoleAdmin,
+	PermEditRoom:      RoleAdmin,
+	PermDeleteRoom:    RoleOwner,
+}
+
+// ロールが権限を持っているか
+func (r RoomRole) Can(perm RoomPermission) bool {
+	return r.Valid() && roleRank[r] >= roleRank[permissionMinRole[perm]]
+}
+
+type RoomMember struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Role     RoomRole  `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// ✅ ユーザーのルーム内ロールを取得（メンバーでなければ空文字）
+func (s *Server) roomRole(roomID, userID int) (RoomRole, error) {
+	var role RoomRole
+	err := s.DB.QueryRow(`SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// ✅ 共通の権限チェック（不足している場合はエラーレスポンスを書いて false を返す）
+// ルーム制限付きのアクセストークンでは、対象のルーム以外はメンバーであっても拒否する
+func (s *Server) requireRoomPermission(w http.ResponseWriter, r *http.Request, roomID, userID int, perm RoomPermission) (RoomRole, bool) {
+	if !utils.AuthFromRequest(r).AllowsRoom(roomID) {
+		http.Error(w, "このトークンではこのルームにアクセスできません", http.StatusForbidden)
+		return "", false
+	}
+	role, err := s.roomRole(roomID, userID)
+	if err != nil {
+		http.Error(w, "ルームデータの取得に失敗しました", http.StatusInternalServerError)
+		return "", false
+	}
+	if role == "" {
+		http.Error(w, "このルームのメンバーではありません", http.StatusForbidden)
+		return "", false
+	}
+	if !role.Can(perm) {
+		http.Error(w, "この操作を行う権限がありません", http.StatusForbidden)
+		return role, false
+	}
+	return role, true
+}
+
+// ユーザーがルームから BAN されているか
+func (s *Server) isBanned(roomID, userID int) (bool, error) {
+	var banned bool
+	err := s.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM room_bans WHERE room_id = $1 AND user_id = $2)
+	`, roomID, userID).Scan(&banned)
+	return banned, err
+}
+
+// URL の room_id を取得し、グループルームであることを確認
+func (s *Server) groupRoomIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	roomID, err := strconv.Atoi(mux.Vars(r)["room_id"])
+	if err != nil {
+		http.Error(w, "無効な room_id", http.StatusBadRequest) // room_id無効
+		return 0, false
+	}
+	// グループ DM はメンバー構成そのものがルームの識別子なので、名前付きグループとして扱わない
+	var isGroup bool
+	err = s.DB.QueryRow(`SELECT is_group AND dm_key IS NULL FROM chat_rooms WHERE id = $1`, roomID).Scan(&isGroup)
+	if err == sql.ErrNoRows {
+		http.Error(w, "ルームが存在していません", http.StatusNotFound)
+		return 0, false
+	} else if err != nil {
+		http.Error(w, "ルームデータの取得に失敗しました", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !isGroup {
+		http.Error(w, "グループルームではありません", http.StatusBadRequest)
+		return 0, false
+	}
+	return roomID, true
+}
+
+// 操作対象のメンバー（URL の user_id）と、その現在のロールを取得
+func (s *Server) targetMember(w http.ResponseWriter, r *http.Request, roomID int) (int, RoomRole, bool) {
+	targetID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		http.Error(w, "無効な user_id", http.StatusBadRequest)
+		return 0, "", false
+	}
+	role, err := s.roomRole(roomID, targetID)
+	if err != nil {
+		http.Error(w, "メンバーの取得に失敗しました", http.StatusInternalServerError)
+		return 0, "", false
+	}
+	return targetID, role, true
+}
+
+func (s *Server) usernameByID(userID int) string {
+	var name string
+	_ = s.DB.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&name)
+	return name
+}
+
+// メンバー関連イベントをルームのメンバー本人の接続（ルーム内と一覧画面）にのみ通知
+// also にはメンバーではなくなったユーザー（キック・BAN された本人など）を指定する
+func (s *Server) broadcastMemberEvent(roomID int, data map[string]any, also ...int) {
+	data["room_id"] = roomID
+	sent := map[int]bool{}
+	for _, userID := range append(s.roomMemberIDs(roomID), also...) {
+		if sent[userID] {
+			continue
+		}
+		sent[userID] = true
+		s.WSHub.SendToUser <- UserMessage{UserID: userID, Data: data}
+	}
+}
+
+// GET /rooms/{room_id}/members メンバー一覧（ロール付き）
+func (s *Server) GetRoomMembersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized) // token無効
+		return
+	}
+	roomID, err := strconv.Atoi(mux.Vars(r)["room_id"])
+	if err != nil {
+		http.Error(w, "無効な room_id", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT rm.user_id, u.username, rm.role, rm.joined_at
+		FROM room_members rm
+		JOIN users u ON u.id = rm.user_id
+		WHERE rm.room_id = $1
+		ORDER BY CASE rm.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 WHEN 'member' THEN 2 ELSE 3 END, u.username
+	`, roomID)
+	if err != nil {
+		http.Error(w, "メンバーの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []RoomMember{}
+	for rows.Next() {
+		var m RoomMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.JoinedAt); err == nil {
+			members = append(members, m)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"members": members})
+}
+
+// POST /rooms/{room_id}/members メンバーを追加（admin 以上）
+func (s *Server) AddRoomMembersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermManageMembers); !ok {
+		return
+	}
+
+	var payload struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "データの解析に失敗しました", http.StatusBadRequest)
+		return
+	}
+
+	added := s.addRoomMembers(roomID, payload.UserIDs, RoleMember)
+	for _, uid := range added {
+		s.broadcastMemberEvent(roomID, map[string]any{
+			"type":    "member_added",
+			"user_id": uid,
+			"user":    s.usernameByID(uid),
+			"by":      userID,
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"added": added})
+}
+
+// BAN されていないユーザーをメンバーとして追加し、新たに追加されたユーザーIDを返す
+func (s *Server) addRoomMembers(roomID int, userIDs []int, role RoomRole) []int {
+	added := []int{}
+	for _, uid := range userIDs {
+		res, err := s.DB.Exec(`
+			INSERT INTO room_members (room_id, user_id, role)
+			SELECT $1, $2, $3
+			WHERE EXISTS (SELECT 1 FROM users WHERE id = $2)
+			  AND NOT EXISTS (SELECT 1 FROM room_bans WHERE room_id = $1 AND user_id = $2)
+			ON CONFLICT DO NOTHING
+		`, roomID, uid, role) // すでに存在する場合は何もしない
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added = append(added, uid)
+		}
+	}
+	return added
+}
+
+// POST /rooms/{room_id}/members/{user_id}/role ロールの昇格・降格
+// owner への変更はオーナー譲渡（元オーナーは admin になる）
+func (s *Server) SetMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	actorRole, ok := s.requireRoomPermission(w, r, roomID, userID, PermManageMembers)
+	if !ok {
+		return
+	}
+	targetID, targetRole, ok := s.targetMember(w, r, roomID)
+	if !ok {
+		return
+	}
+	if targetRole == "" {
+		http.Error(w, "対象ユーザーはメンバーではありません", http.StatusNotFound)
+		return
+	}
+
+	var payload struct {
+		Role RoomRole `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || !payload.Role.Valid() {
+		http.Error(w, "無効なロールです", http.StatusBadRequest)
+		return
+	}
+	if targetID == userID {
+		http.Error(w, "自分のロールは変更できません", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Role == RoleOwner {
+		// ✅ オーナー譲渡（オーナーのみ）
+		if !actorRole.Can(PermDeleteRoom) {
+			http.Error(w, "オーナーのみが譲渡できます", http.StatusForbidden)
+			return
+		}
+		tx, err := s.DB.Begin()
+		if err != nil {
+			http.Error(w, "ロールの更新に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		_, err1 := tx.Exec(`UPDATE room_members SET role = 'admin' WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+		_, err2 := tx.Exec(`UPDATE room_members SET role = 'owner' WHERE room_id = $1 AND user_id = $2`, roomID, targetID)
+		if err1 != nil || err2 != nil || tx.Commit() != nil {
+			http.Error(w, "ロールの更新に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		s.broadcastMemberEvent(roomID, map[string]any{
+			"type": "member_role_changed", "user_id": userID, "user": s.usernameByID(userID), "role": RoleAdmin, "by": userID,
+		})
+	} else {
+		// 自分より下位のメンバーを、自分より下位のロールにのみ変更できる
+		if !actorRole.Outranks(targetRole) || !actorRole.Outranks(payload.Role) {
+			http.Error(w, "この操作を行う権限がありません", http.StatusForbidden)
+			return
+		}
+		_, err = s.DB.Exec(`UPDATE room_members SET role = $3 WHERE room_id = $1 AND user_id = $2`, roomID, targetID, payload.Role)
+		if err != nil {
+			http.Error(w, "ロールの更新に失敗しました", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.broadcastMemberEvent(roomID, map[string]any{
+		"type":    "member_role_changed",
+		"user_id": targetID,
+		"user":    s.usernameByID(targetID),
+		"role":    payload.Role,
+		"by":      userID,
+	})
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "ロールを変更しました",
+		"user_id": targetID,
+		"role":    payload.Role,
+	})
+}
+
+// POST /rooms/{room_id}/members/{user_id}/kick メンバーを退出させる
+func (s *Server) KickMemberHandler(w http.ResponseWriter, r *http.Request) {
+	s.removeMember(w, r, false)
+}
+
+// POST /rooms/{room_id}/members/{user_id}/ban メンバーを退出させ、再参加を禁止する
+func (s *Server) BanMemberHandler(w http.ResponseWriter, r *http.Request) {
+	s.removeMember(w, r, true)
+}
+
+func (s *Server) removeMember(w http.ResponseWriter, r *http.Request, ban bool) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	actorRole, ok := s.requireRoomPermission(w, r, roomID, userID, PermManageMembers)
+	if !ok {
+		return
+	}
+	targetID, targetRole, ok := s.targetMember(w, r, roomID)
+	if !ok {
+		return
+	}
+	if targetID == userID {
+		http.Error(w, "自分自身は対象にできません", http.StatusBadRequest)
+		return
+	}
+	if targetRole == "" && !ban {
+		http.Error(w, "対象ユーザーはメンバーではありません", http.StatusNotFound)
+		return
+	}
+	if targetRole != "" && !actorRole.Outranks(targetRole) {
+		http.Error(w, "この操作を行う権限がありません", http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&payload) // 理由は任意
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "メンバーの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, targetID); err != nil {
+		http.Error(w, "メンバーの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if ban {
+		_, err := tx.Exec(`
+			INSERT INTO room_bans (room_id, user_id, banned_by, reason) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (room_id, user_id) DO UPDATE SET banned_by = $3, reason = $4, created_at = NOW()
+		`, roomID, targetID, userID, payload.Reason)
+		if err != nil {
+			http.Error(w, "BAN の保存に失敗しました", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "メンバーの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	eventType := "member_kicked"
+	message := "メンバーを退出させました"
+	if ban {
+		eventType = "member_banned"
+		message = "メンバーを BAN しました"
+	}
+	s.broadcastMemberEvent(roomID, map[string]any{
+		"type":    eventType,
+		"user_id": targetID,
+		"user":    s.usernameByID(targetID),
+		"by":      userID,
+		"reason":  payload.Reason,
+	}, targetID) // 本人はすでにメンバーではないので直接通知する
+	s.disconnectRoomMember(roomID, targetID)
+
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// POST /rooms/{room_id}/members/{user_id}/unban BAN を解除
+func (s *Server) UnbanMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermManageMembers); !ok {
+		return
+	}
+	targetID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		http.Error(w, "無効な user_id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.DB.Exec(`DELETE FROM room_bans WHERE room_id = $1 AND user_id = $2`, roomID, targetID)
+	if err != nil {
+		http.Error(w, "BAN の解除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "対象ユーザーは BAN されていません", http.StatusNotFound)
+		return
+	}
+
+	s.broadcastMemberEvent(roomID, map[string]any{
+		"type":    "member_unbanned",
+		"user_id": targetID,
+		"user":    s.usernameByID(targetID),
+		"by":      userID,
+	})
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "BAN を解除しました"})
+}
+
+// GET /rooms/{room_id}/bans BAN 一覧（admin 以上）
+func (s *Server) GetRoomBansHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermManageMembers); !ok {
+		return
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT b.user_id, u.username, b.reason, b.created_at
+		FROM room_bans b
+		JOIN users u ON u.id = b.user_id
+		WHERE b.room_id = $1
+		ORDER BY b.created_at DESC
+	`, roomID)
+	if err != nil {
+		http.Error(w, "BAN 一覧の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type banInfo struct {
+		UserID    int       `json:"user_id"`
+		Username  string    `json:"username"`
+		Reason    string    `json:"reason"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	bans := []banInfo{}
+	for rows.Next() {
+		var b banInfo
+		if err := rows.Scan(&b.UserID, &b.Username, &b.Reason, &b.CreatedAt); err == nil {
+			bans = append(bans, b)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"bans": bans})
+}
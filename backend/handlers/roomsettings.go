@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ルーム設定の文字数上限
+const (
+	maxRoomNameLength  = 100
+	maxRoomTopicLength = 250
+	maxRoomDescLength  = 2000
+	maxRoomAvatarSize  = 5 << 20 // 5MB
+)
+
+// アイコンとして受け付ける画像形式
+var roomAvatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+type UpdateRoomRequest struct {
+	RoomName     *string `json:"room_name"`
+	Topic        *string `json:"topic"`
+	Description  *string `json:"description"`
+	IsPublic     *bool   `json:"is_public"`
+	RemoveAvatar bool    `json:"remove_avatar"`
+	// false にするとルーム内の既読表示を無効にする（大人数のグループ向け）
+	ReadReceiptsEnabled *bool `json:"read_receipts_enabled"`
+}
+
+// ルーム名・トピック・説明の入力チェック（問題があればエラーメッセージを返す）
+func validateRoomSettings(name, topic, description string) string {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return "ルーム名を入力してください"
+	case utf8.RuneCountInString(name) > maxRoomNameLength:
+		return fmt.Sprintf("ルーム名は %d 文字以内にしてください", maxRoomNameLength)
+	case utf8.RuneCountInString(topic) > maxRoomTopicLength:
+		return fmt.Sprintf("トピックは %d 文字以内にしてください", maxRoomTopicLength)
+	case utf8.RuneCountInString(description) > maxRoomDescLength:
+		return fmt.Sprintf("説明は %d 文字以内にしてください", maxRoomDescLength)
+	}
+	return ""
+}
+
+// PATCH /rooms/{room_id} ルーム設定を部分更新（admin 以上）
+func (s *Server) UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermEditRoom); !ok {
+		return
+	}
+
+	var req UpdateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "データの解析に失敗しました", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "ルームの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// ✅ 行をロックしてから現在の値を読む（同時の PATCH で互いの変更を上書きしないように）
+	// 削除するアイコンのキーも取得しておく（更新が確定してからストレージから消す）
+	var room RoomInfo
+	var oldAvatar sql.NullString
+	err = scanRoomInfo(tx.QueryRow(`
+		SELECT `+roomInfoColumns+`, cr.avatar_file FROM chat_rooms cr WHERE cr.id = $1 FOR UPDATE
+	`, roomID), &room, &oldAvatar)
+	if err != nil {
+		http.Error(w, "ルームデータの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// 指定された項目だけ上書き
+	if req.RoomName != nil {
+		room.RoomName = strings.TrimSpace(*req.RoomName)
+	}
+	if req.Topic != nil {
+		room.Topic = strings.TrimSpace(*req.Topic)
+	}
+	if req.Description != nil {
+		room.Description = *req.Description
+	}
+	if req.IsPublic != nil {
+		room.IsPublic = *req.IsPublic
+	}
+	if req.ReadReceiptsEnabled != nil {
+		room.ReadReceiptsEnabled = *req.ReadReceiptsEnabled
+	}
+	if msg := validateRoomSettings(room.RoomName, room.Topic, room.Description); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE chat_rooms
+		SET room_name = $2, topic = $3, description = $4, is_public = $5,
+		    avatar_file = CASE WHEN $6 THEN NULL ELSE avatar_file END,
+		    read_receipts_enabled = $7,
+		    updated_at = NOW()
+		WHERE id = $1
+	`, roomID, room.RoomName, room.Topic, room.Description, room.IsPublic, req.RemoveAvatar, room.ReadReceiptsEnabled)
+	if err != nil {
+		http.Error(w, "ルームの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "ルームの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if req.RemoveAvatar {
+		room.AvatarURL = ""
+		if oldAvatar.Valid && oldAvatar.String != "" {
+			s.deleteObject(r.Context(), oldAvatar.String)
+		}
+	}
+
+	s.broadcastRoomUpdated(room, userID)
+	json.NewEncoder(w).Encode(room)
+}
+
+// POST /rooms/{room_id}/avatar ルームアイコンをアップロード（admin 以上）
+func (s *Server) UploadRoomAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "トークンが無効です", http.StatusUnauthorized)
+		return
+	}
+	roomID, ok := s.groupRoomIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermEditRoom); !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRoomAvatarSize+(1<<20))
+	if err := r.ParseMultipartForm(maxRoomAvatarSize); err != nil {
+		http.Error(w, "ファイルサイズが大きすぎます", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "ファイルが提供されていません", http.StatusBadRequest) // 未提供檔案
+		return
+	}
+	defer file.Close()
+
+	// 拡張子ではなく内容から画像形式を判定
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := roomAvatarTypes[contentType]
+	if !ok {
+		http.Error(w, "PNG / JPEG / GIF / WebP 画像のみアップロードできます", http.StatusBadRequest)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "ファイルの読み込みに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	fileName := fmt.Sprintf("room_%d_%d%s", roomID, time.Now().UnixNano(), ext)
+	if err := s.Storage.Put(r.Context(), fileName, file, header.Size, contentType); err != nil {
+		http.Error(w, "ファイル保存に失敗しました", http.StatusInternalServerError) // 無法儲存檔案
+		return
+	}
+
+	// 差し替え前のアイコンは更新が確定してから削除する
+	var room RoomInfo
+	var oldAvatar sql.NullString
+	err = scanRoomInfo(s.DB.QueryRow(`
+		WITH old AS (SELECT id, avatar_file FROM chat_rooms WHERE id = $1 FOR UPDATE)
+		UPDATE chat_rooms cr SET avatar_file = $2, updated_at = NOW()
+		FROM old
+		WHERE cr.id = old.id
+		RETURNING `+roomInfoColumns+`, old.avatar_file`, roomID, fileName), &room, &oldAvatar)
+	if err != nil {
+		s.deleteObject(r.Context(), fileName)
+		http.Error(w, "ルームの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if oldAvatar.Valid && oldAvatar.String != "" && oldAvatar.String != fileName {
+		s.deleteObject(r.Context(), oldAvatar.String)
+	}
+
+	s.broadcastRoomUpdated(room, userID)
+	json.NewEncoder(w).Encode(room)
+}
+
+// room_updated イベントをルームのメンバーだけに通知（ルーム内の接続と一覧画面の両方に届く）
+func (s *Server) broadcastRoomUpdated(room RoomInfo, by int) {
+	data := map[string]any{
+		"type":    "room_updated",
+		"room_id": room.ID,
+		"room":    room,
+		"by":      by,
+	}
+	for _, userID := range s.roomMemberIDs(room.ID) {
+		s.WSHub.SendToUser <- UserMessage{UserID: userID, Data: data}
+	}
+}
+
+// ルームのメンバーのユーザーID一覧
+func (s *Server) roomMemberIDs(roomID int) []int {
+	rows, err := s.DB.Query(`SELECT user_id FROM room_members WHERE room_id = $1`, roomID)
+	if err != nil {
+		log.Println("⚠️ メンバーの取得に失敗:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
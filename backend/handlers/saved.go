@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxSavedNoteLength = 1000
+	defaultSavedLimit  = 50
+	maxSavedLimit      = 100
+)
+
+type SaveMessageRequest struct {
+	Note     string     `json:"note"`
+	RemindAt *time.Time `json:"remind_at"` // 任意：リマインド日時
+}
+
+type SavedMessage struct {
+	ID            int        `json:"id"`
+	MessageID     int        `json:"message_id"`
+	RoomID        int        `json:"room_id"`
+	SenderID      int        `json:"sender_id"`
+	Sender        string     `json:"sender"`
+	Preview       string     `json:"preview"`
+	HasAttachment bool       `json:"has_attachment"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Note          string     `json:"note"`
+	RemindAt      *time.Time `json:"remind_at,omitempty"`
+	SavedAt       time.Time  `json:"saved_at"`
+}
+
+// 保存一覧の共通カラム（保存が s、メッセージが m）
+const savedMessageColumns = `s.id, m.id, m.room_id, m.sender_id, u.username, m.content,
+	EXISTS (SELECT 1 FROM message_attachments a WHERE a.message_id = m.id),
+	m.created_at, s.note, s.remind_at, s.created_at`
+
+func scanSavedMessage(row interface{ Scan(...any) error }, sm *SavedMessage) error {
+	var content string
+	var remindAt sql.NullTime
+	if err := row.Scan(&sm.ID, &sm.MessageID, &sm.RoomID, &sm.SenderID, &sm.Sender, &content,
+		&sm.HasAttachment, &sm.CreatedAt, &sm.Note, &remindAt, &sm.SavedAt); err != nil {
+		return err
+	}
+	sm.Preview = messagePreview(content, sm.HasAttachment)
+	if remindAt.Valid {
+		sm.RemindAt = &remindAt.Time
+	}
+	return nil
+}
+
+// POST /messages/{message_id}/save メッセージを保存（保存済みならメモ・リマインドを更新）
+func (s *Server) SaveMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインが必要です", http.StatusUnauthorized)
+		return
+	}
+	messageID, err := strconv.Atoi(mux.Vars(r)["message_id"])
+	if err != nil {
+		http.Error(w, "メッセージIDが無効です", http.StatusBadRequest)
+		return
+	}
+
+	var req SaveMessageRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "リクエスト形式が正しくありません", http.StatusBadRequest)
+			return
+		}
+	}
+	if utf8.RuneCountInString(req.Note) > maxSavedNoteLength {
+		http.Error(w, fmt.Sprintf("メモは %d 文字以内にしてください", maxSavedNoteLength), http.StatusBadRequest)
+		return
+	}
+	if req.RemindAt != nil && !req.RemindAt.After(time.Now()) {
+		http.Error(w, "リマインド日時には未来の日時を指定してください", http.StatusBadRequest)
+		return
+	}
+
+	// ✅ 閲覧できるメッセージのみ保存できる
+	var roomID int
+	err = s.DB.QueryRow(`SELECT room_id FROM messages WHERE id = $1`, messageID).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "メッセージが存在しません", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "データベースエラー", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+		return
+	}
+
+	var remindAt any
+	if req.RemindAt != nil {
+		remindAt = *req.RemindAt
+	}
+	var savedID int
+	err = s.DB.QueryRow(`
+		INSERT INTO saved_messages (user_id, message_id, note, remind_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, message_id) DO UPDATE
+		SET note = EXCLUDED.note, remind_at = EXCLUDED.remind_at, reminded_at = NULL
+		RETURNING id
+	`, userID, messageID, req.Note, remindAt).Scan(&savedID)
+	if err != nil {
+		http.Error(w, "保存に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	var saved SavedMessage
+	err = scanSavedMessage(s.DB.QueryRow(`
+		SELECT `+savedMessageColumns+`
+		FROM saved_messages s
+		JOIN messages m ON m.id = s.message_id
+		JOIN users u ON u.id = m.sender_id
+		WHERE s.id = $1
+	`, savedID), &saved)
+	if err != nil {
+		http.Error(w, "保存データの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(saved)
+}
+
+// POST /messages/{message_id}/unsave 保存を解除
+func (s *Server) UnsaveMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインが必要です", http.StatusUnauthorized)
+		return
+	}
+	messageID, err := strconv.Atoi(mux.Vars(r)["message_id"])
+	if err != nil {
+		http.Error(w, "メッセージIDが無効です", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.DB.Exec(`DELETE FROM saved_messages WHERE user_id = $1 AND message_id = $2`, userID, messageID)
+	if err != nil {
+		http.Error(w, "保存の解除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "保存されていません", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "保存を解除しました",
+	})
+}
+
+// GET /me/saved?limit=50&before={id} 保存したメッセージ一覧（新しい順）
+// 撤回されたメッセージと、退出したルームのメッセージは含まない
+func (s *Server) GetSavedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインが必要です", http.StatusUnauthorized)
+		return
+	}
+
+	limit := defaultSavedLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit が無効です", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSavedLimit)
+	}
+	before := 0 // 0 = 先頭から
+	if v := r.URL.Query().Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "before が無効です", http.StatusBadRequest)
+			return
+		}
+		before = n
+	}
+
+	// 次ページの有無を判定するため1件多く取得
+	rows, err := s.DB.Query(`
+		SELECT `+savedMessageColumns+`
+		FROM saved_messages s
+		JOIN messages m ON m.id = s.message_id
+		JOIN users u ON u.id = m.sender_id
+		JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = s.user_id
+		WHERE s.user_id = $1 AND ($2 = 0 OR s.id < $2)
+		ORDER BY s.id DESC
+		LIMIT $3
+	`, userID, before, limit+1)
+	if err != nil {
+		http.Error(w, "保存メッセージの取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := []SavedMessage{}
+	for rows.Next() {
+		var sm SavedMessage
+		if err := scanSavedMessage(rows, &sm); err != nil {
+			http.Error(w, "保存メッセージの取得に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		items = append(items, sm)
+	}
+
+	resp := map[string]any{"items": items}
+	if len(items) > limit {
+		items = items[:limit]
+		resp["items"] = items
+		resp["next_before"] = items[limit-1].ID
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// リマインド日時を過ぎた保存メッセージを定期的に通知する（main から goroutine で起動）
+func (s *Server) RunSavedMessageReminders(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.sendDueSavedMessageReminders()
+	}
+}
+
+func (s *Server) sendDueSavedMessageReminders() {
+	rows, err := s.DB.Query(`
+		UPDATE saved_messages SET reminded_at = NOW()
+		WHERE reminded_at IS NULL AND remind_at <= NOW()
+		RETURNING user_id, message_id, note
+	`)
+	if err != nil {
+		log.Println("⚠️ リマインドの取得に失敗:", err)
+		return
+	}
+
+	// 先にすべて読み出してカーソルを閉じてから通知する（送信待ちで接続を占有しない）
+	type reminder struct {
+		userID, messageID int
+		note              string
+	}
+	var due []reminder
+	for rows.Next() {
+		var rem reminder
+		if err := rows.Scan(&rem.userID, &rem.messageID, &rem.note); err != nil {
+			continue
+		}
+		due = append(due, rem)
+	}
+	rows.Close()
+
+	// ✅ メモは本人の接続にだけ送る
+	for _, rem := range due {
+		s.WSHub.SendToUser <- UserMessage{
+			UserID: rem.userID,
+			Data: map[string]any{
+				"type":       "saved_message_reminder",
+				"message_id": rem.messageID,
+				"note":       rem.note,
+			},
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"path"
+	"time"
+)
+
+// マルウェアが検出されて公開されなかった添付ファイル
+type RejectedAttachment struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+}
+
+// ✅ 添付ファイルを公開する前に検査し、感染していたものを隔離する
+// 1つでも検出された場合はアップロードした本人に attachment_rejected を通知する
+// スキャナーに接続できない場合はエラーを返す（検査なしでは公開しない）
+func (s *Server) scanAttachments(ctx context.Context, userID, roomID int, atts []*storedAttachment) ([]RejectedAttachment, error) {
+	if s.Scanner == nil {
+		return nil, nil
+	}
+
+	var rejected []RejectedAttachment
+	for _, a := range atts {
+		body, err := a.open()
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.Scanner.Scan(ctx, body)
+		if err != nil {
+			return nil, err
+		}
+		if !res.Infected {
+			continue
+		}
+		log.Printf("⚠️ マルウェアを検出: user=%d room=%d file=%q signature=%s", userID, roomID, a.FileName, res.Signature)
+		if err := s.quarantineAttachment(ctx, userID, roomID, a, res.Signature); err != nil {
+			log.Println("❌ 隔離に失敗:", err)
+		}
+		rejected = append(rejected, RejectedAttachment{Name: a.FileName, Signature: res.Signature})
+	}
+
+	if len(rejected) > 0 {
+		s.WSHub.SendToUser <- UserMessage{
+			UserID: userID,
+			Data: map[string]any{
+				"type":        "attachment_rejected",
+				"room_id":     roomID,
+				"attachments": rejected,
+			},
+		}
+	}
+	return rejected, nil
+}
+
+// 感染したファイルを quarantine/ 配下に保存して記録する（blob としては登録しない）
+func (s *Server) quarantineAttachment(ctx context.Context, userID, roomID int, a *storedAttachment, signature string) error {
+	key := "quarantine/" + time.Now().UTC().Format("2006/01") + "/" + path.Base(a.StorageKey)
+	body, err := a.open()
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.Put(ctx, key, body, a.Size, "application/octet-stream"); err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO quarantined_attachments (user_id, room_id, file_name, storage_key, content_type, size_bytes, signature)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, userID, roomID, a.FileName, key, a.ContentType, a.Size, signature)
+	return err
+}
+
+// 検査の結果をレスポンスに変換（公開してよければ true）
+func writeScanResult(w http.ResponseWriter, rejected []RejectedAttachment, err error) bool {
+	if err != nil {
+		log.Println("❌ マルウェア検査に失敗:", err)
+		http.Error(w, "ファイルを検査できませんでした。しばらくしてから再度お試しください", http.StatusServiceUnavailable)
+		return false
+	}
+	if len(rejected) > 0 {
+		http.Error(w, "マルウェアが検出されたためアップロードできません（"+rejected[0].Name+"）", http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
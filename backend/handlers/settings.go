@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"backend/utils"
+	"encoding/json"
+	"net/http"
+)
+
+// ユーザーごとの設定
+type UserSettings struct {
+	// true の場合、自分の既読は他のメンバーに表示されない（自分の未読数には影響しない）
+	HideReadReceipts bool `json:"hide_read_receipts"`
+}
+
+type UpdateUserSettingsRequest struct {
+	HideReadReceipts *bool `json:"hide_read_receipts"`
+}
+
+func (s *Server) userSettings(userID int) (UserSettings, error) {
+	var settings UserSettings
+	err := s.DB.QueryRow(`SELECT hide_read_receipts FROM users WHERE id = $1`, userID).Scan(&settings.HideReadReceipts)
+	return settings, err
+}
+
+// GET /me/settings 自分の設定を取得
+func (s *Server) GetUserSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+
+	settings, err := s.userSettings(userID)
+	if err != nil {
+		http.Error(w, "設定の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(settings)
+}
+
+// PATCH /me/settings 自分の設定を部分更新
+func (s *Server) UpdateUserSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+		return
+	}
+
+	var req UpdateUserSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "リクエスト形式が正しくありません", http.StatusBadRequest)
+		return
+	}
+
+	if req.HideReadReceipts != nil {
+		if _, err := s.DB.Exec(`UPDATE users SET hide_read_receipts = $2 WHERE id = $1`, userID, *req.HideReadReceipts); err != nil {
+			http.Error(w, "設定の更新に失敗しました", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	settings, err := s.userSettings(userID)
+	if err != nil {
+		http.Error(w, "設定の取得に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(settings)
+}
@@ -3,7 +3,10 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/mail"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -11,6 +14,7 @@ import (
 type SignupRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email"` // 任意（パスワードリセットの送信先）
 } // クライアントから送られる JSON データ構造
 
 type SignupResponse struct {
@@ -24,6 +28,28 @@ func (s *Server) SignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Username == "" {
+		http.Error(w, "ユーザー名を入力してください", http.StatusBadRequest) // 用户名不可为空
+		return
+	}
+
+	if req.Email != "" {
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Email = email
+	}
+
+	// ✅ パスワード強度チェック
+	if err := s.PasswordPolicy.Validate(req.Password, req.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// ✅ ユーザー名がすでに存在しているかチェック
 	var existingID int
 	err := s.DB.QueryRow("SELECT id FROM users WHERE username = $1", req.Username).Scan(&existingID)
@@ -36,6 +62,20 @@ func (s *Server) SignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ✅ メールアドレスの重複チェック（指定された場合のみ）
+	if req.Email != "" {
+		var emailTaken bool
+		err = s.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1))", req.Email).Scan(&emailTaken)
+		if err != nil {
+			http.Error(w, "データベース照会に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		if emailTaken {
+			http.Error(w, "このメールアドレスは既に使用されています", http.StatusConflict)
+			return
+		}
+	}
+
 	// ✅ パスワードのハッシュ化処理
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -44,7 +84,11 @@ func (s *Server) SignupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ✅ 新しいユーザー情報を挿入
-	_, err = s.DB.Exec("INSERT INTO users (username, password_hash) VALUES ($1, $2)", req.Username, string(hashedPassword))
+	var email sql.NullString
+	if req.Email != "" {
+		email = sql.NullString{String: req.Email, Valid: true}
+	}
+	_, err = s.DB.Exec("INSERT INTO users (username, password_hash, email) VALUES ($1, $2, $3)", req.Username, string(hashedPassword), email)
 	if err != nil {
 		http.Error(w, "登録に失敗しました", http.StatusInternalServerError) // 注册失败
 		return
@@ -52,3 +96,19 @@ func (s *Server) SignupHandler(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(SignupResponse{Message: "登録成功"}) // 注册成功
 }
+
+var errInvalidEmail = errors.New("メールアドレスの形式が正しくありません")
+
+// ✅ メールアドレスを検証して正規化する
+// 表示名付き（"名前 <addr>"）や改行を含むものは拒否し、アドレス部分だけを受け付ける
+func normalizeEmail(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.ContainsAny(raw, "\r\n") {
+		return "", errInvalidEmail
+	}
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Name != "" || addr.Address != raw {
+		return "", errInvalidEmail
+	}
+	return addr.Address, nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"backend/utils"
 	"log"
 	"net/http"
 	"strconv"
@@ -9,21 +10,28 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-// Clients: 各ルームID（int）に対応する WebSocket 接続集合（map[*websocket.Conn]bool）
+// Clients: 各ルームID（int）に対応する WebSocket 接続集合（接続 -> ユーザーID）
 // Register: ユーザー接続を登録するためのチャネル
 // Unregister: ユーザー切断を処理するためのチャネル
+// Disconnect: ルームから外れたユーザーの接続を切断するためのチャネル
 // Broadcast: メッセージを同一ルーム内のすべての接続に送信するためのチャネル
+// SendToUser: 特定のユーザーの接続だけにメッセージを送信するためのチャネル
+// Online: ログイン中のユーザーごとの接続数（オンライン表示用）
 // Mutex: 複数スレッドから Clients を安全に操作するためのロック
 type WebSocketHub struct {
-	Clients    map[int]map[*websocket.Conn]bool // roomID -> 接続セット
+	Clients    map[int]map[*websocket.Conn]int // roomID -> 接続 -> userID
+	Online     map[int]int                     // userID -> 接続数
 	Register   chan ClientConn
 	Unregister chan ClientConn
+	Disconnect chan ClientConn // RoomID と UserID のみ指定
 	Broadcast  chan WSMessage
+	SendToUser chan UserMessage
 	Mutex      sync.Mutex
 }
 
 type ClientConn struct {
 	RoomID int
+	UserID int
 	Conn   *websocket.Conn
 }
 
@@ -32,6 +40,12 @@ type WSMessage struct {
 	Data   map[string]any `json:"data"`
 }
 
+// 本人だけに届ける通知（接続しているすべてのルームの接続に送る）
+type UserMessage struct {
+	UserID int            `json:"user_id"`
+	Data   map[string]any `json:"data"`
+}
+
 // WebSocket にアップグレードするための設定
 var Upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
@@ -40,10 +54,13 @@ var Upgrader = websocket.Upgrader{
 // WebSocketHub の初期化
 func NewHub() *WebSocketHub {
 	return &WebSocketHub{
-		Clients:    make(map[int]map[*websocket.Conn]bool),
+		Clients:    make(map[int]map[*websocket.Conn]int),
+		Online:     make(map[int]int),
 		Register:   make(chan ClientConn),
 		Unregister: make(chan ClientConn),
+		Disconnect: make(chan ClientConn),
 		Broadcast:  make(chan WSMessage),
+		SendToUser: make(chan UserMessage),
 	}
 }
 
@@ -55,9 +72,10 @@ func (hub *WebSocketHub) Run() {
 		case client := <-hub.Register:
 			hub.Mutex.Lock()
 			if hub.Clients[client.RoomID] == nil {
-				hub.Clients[client.RoomID] = make(map[*websocket.Conn]bool)
+				hub.Clients[client.RoomID] = make(map[*websocket.Conn]int)
 			}
-			hub.Clients[client.RoomID][client.Conn] = true
+			hub.Clients[client.RoomID][client.Conn] = client.UserID
+			hub.Online[client.UserID]++
 			hub.Mutex.Unlock()
 
 		// Unregister チャネルから受信し、切断されたユーザーを削除
@@ -67,6 +85,22 @@ func (hub *WebSocketHub) Run() {
 				delete(conns, client.Conn)
 				client.Conn.Close()
 			}
+			if hub.Online[client.UserID]--; hub.Online[client.UserID] <= 0 {
+				delete(hub.Online, client.UserID)
+			}
+			hub.Mutex.Unlock()
+
+		// ✅ キック・BAN・退室したユーザーのルーム接続を切断
+		// （読み取りループが終了して Unregister が送られ、オンライン数はそこで減らす）
+		case client := <-hub.Disconnect:
+			hub.Mutex.Lock()
+			conns := hub.Clients[client.RoomID]
+			for conn, userID := range conns {
+				if userID == client.UserID {
+					conn.Close()
+					delete(conns, conn)
+				}
+			}
 			hub.Mutex.Unlock()
 
 		case msg := <-hub.Broadcast:
@@ -95,30 +129,82 @@ func (hub *WebSocketHub) Run() {
 				}
 			}
 			hub.Mutex.Unlock()
+
+		case msg := <-hub.SendToUser:
+			hub.Mutex.Lock()
+			for _, conns := range hub.Clients {
+				for conn, userID := range conns {
+					if userID != msg.UserID {
+						continue
+					}
+					if err := conn.WriteJSON(msg.Data); err != nil {
+						log.Println("🔴 WebSocket 書き込みに失敗:", err)
+						conn.Close()
+						delete(conns, conn)
+					}
+				}
+			}
+			hub.Mutex.Unlock()
 		}
 	}
 }
 
+// ユーザーが WebSocket で接続中かどうか
+func (hub *WebSocketHub) IsOnline(userID int) bool {
+	hub.Mutex.Lock()
+	defer hub.Mutex.Unlock()
+	return hub.Online[userID] > 0
+}
+
 // WebSocket ハンドラー（HTTP を WebSocket にアップグレードし、Hub に登録）
+// room_id=0 はログイン中のユーザー全体向けの通知、それ以外はルームのメンバーのみ接続できる
 func (s *Server) WebSocketHandler(hub *WebSocketHub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		roomID, _ := strconv.Atoi(r.URL.Query().Get("room_id"))
+		userID, err := utils.GetUserIDFromToken(r)
+		if err != nil {
+			http.Error(w, "ログインされていません", http.StatusUnauthorized) // 未登入
+			return
+		}
+		roomID, err := strconv.Atoi(r.URL.Query().Get("room_id"))
+		if err != nil && r.URL.Query().Get("room_id") != "" {
+			http.Error(w, "無効な room_id", http.StatusBadRequest)
+			return
+		}
+		if roomID != 0 {
+			if _, ok := s.requireRoomPermission(w, r, roomID, userID, PermViewRoom); !ok {
+				return
+			}
+		}
 		conn, err := Upgrader.Upgrade(w, r, nil) // HTTP を WebSocket にアップグレード
 		if err != nil {
 			log.Println("❌ WebSocket アップグレード失敗:", err) // WebSocket 升級失敗
 			return
 		}
 		// クライアントを Hub に登録
-		client := ClientConn{RoomID: roomID, Conn: conn}
+		client := ClientConn{RoomID: roomID, UserID: userID, Conn: conn}
 		hub.Register <- client
+		s.touchLastSeen(userID)
 
 		// 接続からメッセージ読み取りを継続（読み取りが終了したら切断）
 		for {
 			var dummy map[string]any
 			if err := conn.ReadJSON(&dummy); err != nil {
 				hub.Unregister <- client
+				s.touchLastSeen(userID)
 				break
 			}
 		}
 	}
 }
+
+// ✅ ルームから外れたユーザーの WebSocket 接続を切断する
+func (s *Server) disconnectRoomMember(roomID, userID int) {
+	s.WSHub.Disconnect <- ClientConn{RoomID: roomID, UserID: userID}
+}
+
+// 最終オンライン日時を更新（接続時と切断時）
+func (s *Server) touchLastSeen(userID int) {
+	if _, err := s.DB.Exec(`UPDATE users SET last_seen_at = NOW() WHERE id = $1`, userID); err != nil {
+		log.Println("⚠️ 最終オンライン日時の更新に失敗:", err)
+	}
+}
@@ -0,0 +1,151 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 送信するメール
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// メール送信の差し替え可能なインターフェース
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ✅ 環境変数 MAIL_SENDER に応じて Sender を生成する
+//
+//	（未設定）: メール送信なし（nil を返す。パスワードリセットは無効になる）
+//	log  : ログに出力するだけ（開発用。リセットリンクがログに残るので本番では使わない）
+//	file : MAIL_DIR（デフォルト tmp/mail）に .eml として保存（開発用）
+//	smtp : SMTP_HOST / SMTP_PORT / SMTP_USER / SMTP_PASSWORD / MAIL_FROM で送信
+func NewSenderFromEnv() (Sender, error) {
+	switch driver := os.Getenv("MAIL_SENDER"); driver {
+	case "":
+		return nil, nil
+	case "log":
+		return LogSender{}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = filepath.Join("tmp", "mail")
+		}
+		return &FileSender{Dir: dir, From: mailFrom()}, nil
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("mail: MAIL_SENDER=smtp には SMTP_HOST が必要です")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPSender{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("mail: 不明な MAIL_SENDER %q", driver)
+	}
+}
+
+func mailFrom() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@localhost"
+}
+
+// LogSender はメール内容をログに出力する（開発用）
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	log.Printf("📧 メール送信 (log) to=%s subject=%s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileSender はメールを Dir 配下に .eml ファイルとして書き出す（開発用）
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (f *FileSender) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	data, err := buildRFC822(f.From, msg)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), sanitizeAddr(msg.To))
+	return os.WriteFile(filepath.Join(f.Dir, name), data, 0o600)
+}
+
+// SMTPSender は SMTP サーバー経由でメールを送信する
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	data, err := buildRFC822(s.From, msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.Host+":"+s.Port, auth, s.From, []string{msg.To}, data)
+}
+
+// ヘッダーに改行が含まれている（ヘッダーインジェクション）
+var ErrInvalidHeader = errors.New("mail: ヘッダーに改行を含めることはできません")
+
+// RFC 822 形式のメール本文を組み立てる（UTF-8 本文）
+// 件名は B エンコードするが、アドレスはそのまま書き込むので改行を含むものは拒否する
+func buildRFC822(from string, msg Message) ([]byte, error) {
+	if strings.ContainsAny(from, "\r\n") || strings.ContainsAny(msg.To, "\r\n") {
+		return nil, ErrInvalidHeader
+	}
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// ファイル名に使えない文字を置換
+func sanitizeAddr(addr string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, addr)
+}
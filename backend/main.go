@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
+	"time"
 
 	"backend/handlers"
+	"backend/mail"
 	"backend/middleware"
+	"backend/migrations"
+	"backend/oidc"
+	"backend/scanner"
+	"backend/storage"
+	"backend/utils"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
@@ -24,7 +32,54 @@ func main() {
 		log.Fatal("❌ データベース接続確認失敗:", err) // 資料庫連線失敗
 	}
 
-	s := &handlers.Server{DB: db}
+	// スキーマのマイグレーションを適用
+	if err := migrations.Run(db); err != nil {
+		log.Fatal("❌ マイグレーション失敗:", err)
+	}
+
+	// JWT ミドルウェアでセッション失効をチェックするため DB を渡す
+	middleware.DB = db
+
+	// 添付ファイルの保存先（STORAGE_BACKEND: local / s3 / memory）
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatal("❌ ストレージの初期化に失敗:", err)
+	}
+
+	// 添付ファイルのマルウェア検査（SCANNER_BACKEND: none / clamav）
+	scan, err := scanner.NewFromEnv()
+	if err != nil {
+		log.Fatal("❌ スキャナーの初期化に失敗:", err)
+	}
+
+	// メール送信（MAIL_SENDER: log / file / smtp。未設定ならパスワードリセットは無効）
+	mailer, err := mail.NewSenderFromEnv()
+	if err != nil {
+		log.Fatal("❌ メール送信の初期化に失敗:", err)
+	}
+	if mailer == nil {
+		log.Println("⚠️ MAIL_SENDER が未設定のため、パスワードリセットは無効です")
+	}
+
+	s := &handlers.Server{
+		DB:               db,
+		PasswordPolicy:   utils.PasswordPolicyFromEnv(),
+		Mailer:           mailer,
+		Storage:          store,
+		AttachmentPolicy: utils.AttachmentPolicyFromEnv(),
+		Scanner:          scan,
+	}
+
+	// OpenID Connect（OIDC_ISSUER が設定されている場合のみ有効）
+	if cfg := oidc.ConfigFromEnv(); cfg != nil {
+		provider, err := oidc.NewProvider(context.Background(), *cfg)
+		if err != nil {
+			log.Println("⚠️ OIDC プロバイダーの初期化に失敗（SSO 無効）:", err)
+		} else {
+			s.OIDC = provider
+			log.Println("✅ OIDC 有効:", provider.Issuer())
+		}
+	}
 	r := mux.NewRouter().StrictSlash(true)
 
 	// リクエストログ用ミドルウェア
@@ -38,6 +93,12 @@ func main() {
 	// 公開エンドポイント
 	r.HandleFunc("/signup", s.SignupHandler).Methods("POST")
 	r.HandleFunc("/login", s.LoginHandler).Methods("POST")
+	// パスワードリセット（メールで受け取ったトークンを使用）
+	r.HandleFunc("/password-reset/request", s.RequestPasswordResetHandler).Methods("POST")
+	r.HandleFunc("/password-reset/confirm", s.ConfirmPasswordResetHandler).Methods("POST")
+	// シングルサインオン（OpenID Connect）
+	r.HandleFunc("/auth/oidc/login", s.OIDCLoginHandler).Methods("GET")
+	r.HandleFunc("/auth/oidc/callback", s.OIDCCallbackHandler).Methods("GET")
 
 	// 保護されたエンドポイント（CookieベースのJWT検証）
 	r.Handle("/get-or-create-room", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetOrCreateRoomHandler))).Methods("POST")
@@ -49,18 +110,49 @@ func main() {
 	r.Handle("/messages/{message_id}/revoke", middleware.JWTAuthMiddleware(http.HandlerFunc(s.RevokeMessageHandler))).Methods("POST")
 	// メッセージ削除（本人の画面からのみ非表示）
 	r.Handle("/messages/{message_id}/hide", middleware.JWTAuthMiddleware(http.HandlerFunc(s.HideMessageHandler))).Methods("POST")
+	// メッセージのピン留め（ルーム内で共有）
+	r.Handle("/messages/{message_id}/pin", middleware.JWTAuthMiddleware(http.HandlerFunc(s.PinMessageHandler))).Methods("POST")
+	r.Handle("/messages/{message_id}/unpin", middleware.JWTAuthMiddleware(http.HandlerFunc(s.UnpinMessageHandler))).Methods("POST")
+	r.Handle("/rooms/{room_id}/pins", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetRoomPinsHandler))).Methods("GET")
+	// 保存したメッセージ（本人のみ）
+	r.Handle("/messages/{message_id}/save", middleware.JWTAuthMiddleware(http.HandlerFunc(s.SaveMessageHandler))).Methods("POST")
+	r.Handle("/messages/{message_id}/unsave", middleware.JWTAuthMiddleware(http.HandlerFunc(s.UnsaveMessageHandler))).Methods("POST")
+	r.Handle("/me/saved", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetSavedMessagesHandler))).Methods("GET")
+	// 添付ファイルの使用量
+	r.Handle("/me/storage", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetMyStorageHandler))).Methods("GET")
 
 	// ✅ グループチャット関連のエンドポイント（命名規則として rooms 使用）
 	r.Handle("/rooms", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetUserRoomsHandler))).Methods("GET")
+	r.Handle("/group-dm", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetOrCreateGroupDMHandler))).Methods("POST")
 	r.Handle("/create-group-room", middleware.JWTAuthMiddleware(http.HandlerFunc(s.CreateGroupRoomHandler))).Methods("POST")
 	r.Handle("/rooms/{room_id}/join-group", middleware.JWTAuthMiddleware(http.HandlerFunc(s.JoinGroupRoomHandler))).Methods("GET")
 	r.Handle("/rooms/{room_id}/info", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetRoomInfoHandler))).Methods("GET")
+	// ✅ ルーム設定（名前・トピック・説明・アイコン）
+	r.Handle("/rooms/{room_id}", middleware.JWTAuthMiddleware(http.HandlerFunc(s.UpdateRoomHandler))).Methods("PATCH")
+	r.Handle("/rooms/{room_id}/avatar", middleware.JWTAuthMiddleware(http.HandlerFunc(s.UploadRoomAvatarHandler))).Methods("POST")
 	r.Handle("/rooms/{room_id}/leave", middleware.JWTAuthMiddleware(http.HandlerFunc(s.LeaveGroupHandler))).Methods("POST")
+	// ✅ メンバー管理（ロール・キック・BAN）
+	r.Handle("/rooms/{room_id}/members", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetRoomMembersHandler))).Methods("GET")
+	r.Handle("/rooms/{room_id}/members", middleware.JWTAuthMiddleware(http.HandlerFunc(s.AddRoomMembersHandler))).Methods("POST")
+	r.Handle("/rooms/{room_id}/members/{user_id}/role", middleware.JWTAuthMiddleware(http.HandlerFunc(s.SetMemberRoleHandler))).Methods("POST")
+	r.Handle("/rooms/{room_id}/members/{user_id}/kick", middleware.JWTAuthMiddleware(http.HandlerFunc(s.KickMemberHandler))).Methods("POST")
+	r.Handle("/rooms/{room_id}/members/{user_id}/ban", middleware.JWTAuthMiddleware(http.HandlerFunc(s.BanMemberHandler))).Methods("POST")
+	r.Handle("/rooms/{room_id}/members/{user_id}/unban", middleware.JWTAuthMiddleware(http.HandlerFunc(s.UnbanMemberHandler))).Methods("POST")
+	r.Handle("/rooms/{room_id}/bans", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetRoomBansHandler))).Methods("GET")
+	// ✅ 招待リンク（グループはデフォルトで招待制。公開への切り替えは PATCH /rooms/{room_id} の is_public）
+	r.Handle("/rooms/{room_id}/invites", middleware.JWTAuthMiddleware(http.HandlerFunc(s.CreateInviteHandler))).Methods("POST")
+	r.Handle("/rooms/{room_id}/invites", middleware.JWTAuthMiddleware(http.HandlerFunc(s.ListInvitesHandler))).Methods("GET")
+	r.Handle("/rooms/{room_id}/invites/{invite_id}/revoke", middleware.JWTAuthMiddleware(http.HandlerFunc(s.RevokeInviteHandler))).Methods("POST")
+	r.Handle("/invites/{code}", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetInviteHandler))).Methods("GET")
+	r.Handle("/invites/{code}/join", middleware.JWTAuthMiddleware(http.HandlerFunc(s.JoinByInviteHandler))).Methods("POST")
 	log.Println("✅ /create-group-room を含むすべてのルートが登録されました")
 
 	// メッセージ既読処理
 	r.Handle("/messages/{message_id}/markread", middleware.JWTAuthMiddleware(http.HandlerFunc(s.MarkMessageAsReadHandler))).Methods("POST")
 	r.Handle("/rooms/{room_id}/unread-count", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetUnreadMessageCountHandler))).Methods("GET")
+	r.Handle("/rooms/{room_id}/preferences", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetRoomPreferencesHandler))).Methods("GET")
+	r.Handle("/rooms/{room_id}/preferences", middleware.JWTAuthMiddleware(http.HandlerFunc(s.UpdateRoomPreferencesHandler))).Methods("PATCH")
+	r.Handle("/rooms/{room_id}/mark-unread", middleware.JWTAuthMiddleware(http.HandlerFunc(s.MarkRoomUnreadHandler))).Methods("POST")
 	r.Handle("/messages/{message_id}/readers", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetMessageReadsHandler))).Methods("GET")
 
 	// 一対一チャットルームの取得
@@ -70,11 +162,27 @@ func main() {
 	r.Handle("/rooms/{room_id}/enter", middleware.JWTAuthMiddleware(http.HandlerFunc(s.EnterRoomHandler))).Methods("POST")
 	//tokenの取得
 	r.Handle("/me", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetMeHandler))).Methods("GET")
+	// ユーザー設定（既読の表示など）
+	r.Handle("/me/settings", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetUserSettingsHandler))).Methods("GET")
+	r.Handle("/me/settings", middleware.JWTAuthMiddleware(http.HandlerFunc(s.UpdateUserSettingsHandler))).Methods("PATCH")
+	// パスワード変更（他のセッションは失効）
+	r.Handle("/me/password", middleware.JWTAuthMiddleware(http.HandlerFunc(s.ChangePasswordHandler))).Methods("POST")
+	// メールアドレスの変更（パスワードリセットの送信先）
+	r.Handle("/me/email", middleware.JWTAuthMiddleware(http.HandlerFunc(s.ChangeEmailHandler))).Methods("PUT")
+	// パーソナルアクセストークン（Authorization: Bearer cat_...）
+	r.Handle("/me/tokens", middleware.JWTAuthMiddleware(http.HandlerFunc(s.CreateAccessTokenHandler))).Methods("POST")
+	r.Handle("/me/tokens", middleware.JWTAuthMiddleware(http.HandlerFunc(s.ListAccessTokensHandler))).Methods("GET")
+	r.Handle("/me/tokens/{token_id}/revoke", middleware.JWTAuthMiddleware(http.HandlerFunc(s.RevokeAccessTokenHandler))).Methods("POST")
+	// ボットアカウント（オーナーがトークンを発行）
+	r.Handle("/bots", middleware.JWTAuthMiddleware(http.HandlerFunc(s.CreateBotHandler))).Methods("POST")
+	r.Handle("/bots", middleware.JWTAuthMiddleware(http.HandlerFunc(s.ListBotsHandler))).Methods("GET")
+	r.Handle("/bots/{bot_id}/tokens", middleware.JWTAuthMiddleware(http.HandlerFunc(s.CreateBotTokenHandler))).Methods("POST")
+	r.Handle("/bots/{bot_id}/tokens", middleware.JWTAuthMiddleware(http.HandlerFunc(s.ListBotTokensHandler))).Methods("GET")
 	//tokenの削除
 	r.Handle("/logout", http.HandlerFunc(s.LogoutHandler)).Methods("POST")
 	// r.Handle("/mentions", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetMentionNotificationsHandler))).Methods("GET")
 	r.Handle("/mention-notifications", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetMentionNotifications))).Methods("GET")
-	r.Handle("/downloads/{filename}", middleware.JWTAuthMiddleware(http.HandlerFunc(s.DownloadAttachmentHandler))).Methods("GET")
+	r.Handle("/downloads/{key:.+}", middleware.JWTAuthMiddleware(http.HandlerFunc(s.DownloadAttachmentHandler))).Methods("GET", "HEAD")
 
 	//// WebSocket Hub を初期化
 	hub := handlers.NewHub()
@@ -82,23 +190,37 @@ func main() {
 	go hub.Run()
 	// Hub を Server 構造体にバインド
 	s.WSHub = hub
+	// 保存メッセージのリマインド通知
+	go s.RunSavedMessageReminders(time.Minute)
+	// 期限切れの再開可能アップロードを削除
+	go s.RunUploadSessionCleanup(time.Hour)
+	// どのメッセージからも参照されていない添付ファイルを削除
+	go s.RunAttachmentGC(time.Hour)
 
 	// WebSocket 接続エンドポイント
-	r.HandleFunc("/ws", s.WebSocketHandler(hub))
+	r.Handle("/ws", middleware.JWTAuthMiddleware(s.WebSocketHandler(hub)))
 
 	// CORS 設定
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "http://localhost:3001"},
 		AllowCredentials: true,
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Range", "If-Range", "If-None-Match", "If-Modified-Since"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PATCH", "DELETE", "OPTIONS"},
+		ExposedHeaders:   []string{"Location", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Expires", "Accept-Ranges", "Content-Range", "ETag"},
 	})
 
 	// ✅ 添付ファイルのアップロードエンドポイント
 	r.Handle("/messages/upload", middleware.JWTAuthMiddleware(http.HandlerFunc(s.UploadMessageAttachmentHandler))).Methods("POST")
 
-	// ✅ 静的ファイル（画像）を提供 /uploads/xx.jpg
-	r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("public/uploads"))))
+	// ✅ 再開可能なアップロード（tus 互換）
+	r.Handle("/resumable-uploads", middleware.JWTAuthMiddleware(http.HandlerFunc(s.CreateUploadHandler))).Methods("POST")
+	r.Handle("/resumable-uploads/{upload_id}", middleware.JWTAuthMiddleware(http.HandlerFunc(s.GetUploadOffsetHandler))).Methods("HEAD")
+	r.Handle("/resumable-uploads/{upload_id}", middleware.JWTAuthMiddleware(http.HandlerFunc(s.PatchUploadHandler))).Methods("PATCH")
+	r.Handle("/resumable-uploads/{upload_id}", middleware.JWTAuthMiddleware(http.HandlerFunc(s.DeleteUploadHandler))).Methods("DELETE")
+	r.Handle("/resumable-uploads/{upload_id}/complete", middleware.JWTAuthMiddleware(http.HandlerFunc(s.CompleteUploadHandler))).Methods("POST")
+
+	// ✅ 署名付き URL で添付ファイル・アイコンを提供 /files/{期限}/{署名}/{キー}
+	r.HandleFunc("/files/{exp}/{sig}/{key:.+}", s.SignedFileHandler).Methods("GET", "HEAD")
 
 	log.Println("🚀 サーバー起動: http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", c.Handler(r)))
@@ -0,0 +1,213 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// サムネイルの最大サイズ（長辺）
+	ThumbnailMaxSize = 320
+	// 展開時のメモリを抑えるため、これを超える画素数の画像はデコードしない
+	maxDecodePixels = 50_000_000
+)
+
+// 画像が大きすぎてデコードできない
+var ErrImageTooLarge = errors.New("media: image too large")
+
+// 画像アップロードの処理結果
+type ImageResult struct {
+	Data   []byte // メタデータ（EXIF / GPS など）を除いた画像
+	Width  int
+	Height int
+
+	Thumbnail            []byte // 元画像が十分小さい、またはデコードできない形式の場合は nil
+	ThumbnailContentType string
+	ThumbnailWidth       int
+	ThumbnailHeight      int
+}
+
+// ✅ 画像からメタデータを取り除き、サイズを記録してサムネイルを生成する
+// JPEG の向き（EXIF Orientation）は画素に反映してからメタデータを削除する
+func ProcessImage(data []byte, contentType string) (*ImageResult, error) {
+	res := &ImageResult{Data: data}
+
+	switch contentType {
+	case "image/jpeg":
+		cleaned, orientation, err := stripJPEGMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		res.Data = cleaned
+		if orientation > 1 {
+			// 向きの情報を消すと表示が回転してしまうため、画素を回転させて再エンコードする
+			img, err := decodeLimited(cleaned, jpeg.Decode)
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: 90}); err != nil {
+				return nil, err
+			}
+			res.Data = buf.Bytes()
+		}
+	case "image/png":
+		cleaned, err := stripPNGMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		res.Data = cleaned
+	case "image/webp":
+		cleaned, err := stripWebPMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		res.Data = cleaned
+		// WebP は標準ライブラリでデコードできないためサイズのみ記録する
+		res.Width, res.Height, err = webpSize(cleaned)
+		return res, err
+	case "image/gif":
+		// GIF には位置情報などのメタデータがないのでそのまま保存する
+	default:
+		return res, nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(res.Data))
+	if err != nil {
+		return nil, err
+	}
+	res.Width, res.Height = cfg.Width, cfg.Height
+	if res.Width <= ThumbnailMaxSize && res.Height <= ThumbnailMaxSize {
+		return res, nil
+	}
+
+	decode := map[string]func(io.Reader) (image.Image, error){
+		"image/jpeg": jpeg.Decode,
+		"image/png":  png.Decode,
+		"image/gif":  gif.Decode, // 最初のフレーム
+	}[contentType]
+	img, err := decodeLimited(res.Data, decode)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := Thumbnail(img, ThumbnailMaxSize)
+	var buf bytes.Buffer
+	if contentType == "image/png" || contentType == "image/gif" {
+		// 透過を保つため PNG で保存
+		err = png.Encode(&buf, thumb)
+		res.ThumbnailContentType = "image/png"
+	} else {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		res.ThumbnailContentType = "image/jpeg"
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Thumbnail = buf.Bytes()
+	res.ThumbnailWidth, res.ThumbnailHeight = thumb.Bounds().Dx(), thumb.Bounds().Dy()
+	return res, nil
+}
+
+// ✅ ヘッダーだけを読んで画素数が上限以内か確認する（大きすぎる画像を本体を読み込む前に拒否するため）
+// WebP はデコードしないので確認しない
+func CheckImageSize(r io.Reader, contentType string) error {
+	if contentType == "image/webp" {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxDecodePixels {
+		return ErrImageTooLarge
+	}
+	return nil
+}
+
+func decodeLimited(data []byte, decode func(io.Reader) (image.Image, error)) (image.Image, error) {
+	if err := CheckImageSize(bytes.NewReader(data), ""); err != nil {
+		return nil, err
+	}
+	return decode(bytes.NewReader(data))
+}
+
+// ✅ 長辺が maxSize に収まるよう縮小する（面積平均法）
+func Thumbnail(src image.Image, maxSize int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w >= h && w > maxSize {
+		tw, th = maxSize, max(1, h*maxSize/w)
+	} else if h > w && h > maxSize {
+		tw, th = max(1, w*maxSize/h), maxSize
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	if tw == w && th == h {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, bl, a = r+uint64(p[0]), g+uint64(p[1]), bl+uint64(p[2]), a+uint64(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(bl/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// EXIF Orientation（2〜8）に従って画像を回転・反転する
+func applyOrientation(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 左右反転
+				dx, dy = w-1-x, y
+			case 3: // 180度回転
+				dx, dy = w-1-x, h-1-y
+			case 4: // 上下反転
+				dx, dy = x, h-1-y
+			case 5: // 転置
+				dx, dy = y, x
+			case 6: // 時計回りに90度
+				dx, dy = h-1-y, x
+			case 7: // 反転 + 時計回りに90度
+				dx, dy = h-1-y, w-1-x
+			case 8: // 反時計回りに90度
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
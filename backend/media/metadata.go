@@ -0,0 +1,186 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("media: malformed image")
+
+// ✅ JPEG から EXIF・XMP・IPTC・コメントのセグメントを取り除く（画素は再エンコードしない）
+// 取り除く前の EXIF Orientation も返す（なければ 0）
+func stripJPEGMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	orientation := 0
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, 0, errMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // パディング
+			i++
+			continue
+		}
+		// SOS 以降は画像データなのでそのままコピー
+		if marker == 0xDA {
+			out.Write(data[i:])
+			return out.Bytes(), orientation, nil
+		}
+		// 長さを持たないマーカー
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errMalformed
+		}
+		payload := data[i+4 : end]
+
+		drop := false
+		switch {
+		case marker == 0xE1: // APP1: EXIF / XMP
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(payload[6:])
+			}
+			drop = true
+		case marker == 0xED, marker == 0xFE: // APP13（IPTC）、コメント
+			drop = true
+		}
+		if !drop {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return nil, 0, errMalformed
+}
+
+// TIFF 形式の EXIF から Orientation（タグ 0x0112）を読む
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			v := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if v >= 1 && v <= 8 {
+				return v
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// PNG から取り除くチャンク（EXIF・テキスト・タイムスタンプ）
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true,
+}
+
+// ✅ PNG からメタデータのチャンクを取り除く
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(sig)) {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(sig)
+	for i := len(sig); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+// ✅ WebP（RIFF）から EXIF・XMP チャンクを取り除き、VP8X のフラグとサイズを更新する
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	vp8x := -1
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		end := i + 8 + size + size%2 // チャンクは偶数バイトに揃えられる
+		if end > len(data) {
+			end = len(data)
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			vp8x = out.Len()
+			out.Write(data[i:end])
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(b)-8))
+	if vp8x >= 0 && vp8x+9 <= len(b) {
+		b[vp8x+8] &^= 0x08 | 0x04 // EXIF / XMP フラグを下ろす
+	}
+	return b, nil
+}
+
+// WebP の幅・高さ（VP8X / VP8 / VP8L のヘッダーから読む）
+func webpSize(data []byte) (int, int, error) {
+	if len(data) < 30 {
+		return 0, 0, errMalformed
+	}
+	chunk := data[12:]
+	switch string(chunk[:4]) {
+	case "VP8X":
+		w := int(chunk[12]) | int(chunk[13])<<8 | int(chunk[14])<<16
+		h := int(chunk[15]) | int(chunk[16])<<8 | int(chunk[17])<<16
+		return w + 1, h + 1, nil
+	case "VP8 ":
+		return int(binary.LittleEndian.Uint16(chunk[14:16]) & 0x3FFF), int(binary.LittleEndian.Uint16(chunk[16:18]) & 0x3FFF), nil
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(chunk[9:13])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1, nil
+	}
+	return 0, 0, errMalformed
+}
@@ -2,10 +2,14 @@ package middleware
 
 import (
 	"backend/utils"
+	"database/sql"
 	"net/http"
 	"strings"
 )
 
+// セッション失効チェック・アクセストークン検証に使用する DB（main で設定する）
+var DB *sql.DB
+
 func JWTAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// ✅ ホワイトリストのパス（サインアップ・ログイン）は検証不要
@@ -16,6 +20,21 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// ✅ パーソナルアクセストークン（スクリプト・ボット用）
+		if pat := utils.AccessTokenFromRequest(r); pat != "" && DB != nil {
+			info, err := utils.LookupAccessToken(DB, pat)
+			if err != nil {
+				http.Error(w, "アクセストークンが無効です", http.StatusUnauthorized)
+				return
+			}
+			if !info.HasScope(utils.RequiredScope(r)) {
+				http.Error(w, "このトークンには権限がありません", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(utils.WithAuth(r.Context(), info)))
+			return
+		}
+
 		// ✅ トークン文字列を初期化
 		var tokenString string
 
@@ -34,13 +53,20 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		// ✅ トークン検証
-		_, err = utils.ValidateJWT(tokenString)
+		claims, err := utils.ValidateJWT(tokenString)
 		if err != nil {
 			http.Error(w, "トークンが無効または期限切れです", http.StatusUnauthorized) // Token 无效或已过期
 			return
 		}
 
-		// ✅ 検証成功、次のハンドラーへ
-		next.ServeHTTP(w, r)
+		// ✅ セッションが失効していないか確認（ログアウト・パスワード変更後のトークンを拒否）
+		if DB != nil && !utils.IsSessionActive(DB, claims.ID) {
+			http.Error(w, "セッションが無効です。再度ログインしてください", http.StatusUnauthorized)
+			return
+		}
+
+		// ✅ 検証成功、認証情報をコンテキストに入れて次のハンドラーへ
+		info := &utils.AuthInfo{UserID: claims.UserID, SessionID: claims.ID}
+		next.ServeHTTP(w, r.WithContext(utils.WithAuth(r.Context(), info)))
 	})
 }
@@ -0,0 +1,56 @@
+-- 既存スキーマ（手動で作成されていたテーブル）
+-- 既存 DB に対しては何もしないよう IF NOT EXISTS で定義する
+
+CREATE TABLE IF NOT EXISTS users (
+	id            SERIAL PRIMARY KEY,
+	username      TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chat_rooms (
+	id        SERIAL PRIMARY KEY,
+	room_name TEXT NOT NULL,
+	is_group  BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS room_members (
+	room_id INT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	PRIMARY KEY (room_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id             SERIAL PRIMARY KEY,
+	room_id        INT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+	sender_id      INT NOT NULL REFERENCES users(id),
+	content        TEXT NOT NULL DEFAULT '',
+	created_at     TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at     TIMESTAMP NOT NULL DEFAULT NOW(),
+	thread_root_id INT REFERENCES messages(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS message_attachments (
+	id         SERIAL PRIMARY KEY,
+	message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	file_name  TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS message_reads (
+	message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	read_at    TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (message_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS message_hidden (
+	message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	PRIMARY KEY (message_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS mentions (
+	id                SERIAL PRIMARY KEY,
+	message_id        INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	mention_target_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,21 @@
+-- OpenID Connect ログイン（user-027）
+
+-- 外部 IdP のアカウントとローカルユーザーの紐付け
+CREATE TABLE IF NOT EXISTS user_identities (
+	id         SERIAL PRIMARY KEY,
+	user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	issuer     TEXT NOT NULL,
+	subject    TEXT NOT NULL,
+	email      TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	UNIQUE (issuer, subject)
+);
+
+-- 認可リクエストの一時保存（state / nonce / PKCE code_verifier）
+CREATE TABLE IF NOT EXISTS oidc_auth_requests (
+	state         TEXT PRIMARY KEY,
+	nonce         TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	created_at    TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at    TIMESTAMP NOT NULL
+);
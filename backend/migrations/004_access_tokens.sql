@@ -0,0 +1,20 @@
+-- パーソナルアクセストークンとボットアカウント（user-028）
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bot_owner_id INT REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+	id           SERIAL PRIMARY KEY,
+	user_id      INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name         TEXT NOT NULL,
+	token_hash   TEXT NOT NULL UNIQUE,
+	token_prefix TEXT NOT NULL,              -- 一覧表示用（先頭数文字のみ）
+	scopes       TEXT[] NOT NULL,
+	room_id      INT REFERENCES chat_rooms(id) ON DELETE CASCADE, -- 投稿先ルームの制限（NULL = 制限なし）
+	created_by   INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at   TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at   TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS personal_access_tokens_user_id_idx ON personal_access_tokens (user_id);
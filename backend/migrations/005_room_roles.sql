@@ -0,0 +1,32 @@
+-- グループルームのロールと BAN（user-029）
+
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS joined_at TIMESTAMP NOT NULL DEFAULT NOW();
+ALTER TABLE room_members DROP CONSTRAINT IF EXISTS room_members_role_check;
+ALTER TABLE room_members ADD CONSTRAINT room_members_role_check
+	CHECK (role IN ('owner', 'admin', 'member', 'readonly'));
+
+-- 既存グループにはオーナー情報がないため、最初に発言したメンバー
+-- （発言がなければ user_id が最小のメンバー）をオーナーとする
+UPDATE room_members rm SET role = 'owner'
+FROM (
+	SELECT DISTINCT ON (rm2.room_id) rm2.room_id, rm2.user_id
+	FROM room_members rm2
+	JOIN chat_rooms cr ON cr.id = rm2.room_id AND cr.is_group = true
+	ORDER BY rm2.room_id,
+	         (SELECT MIN(m.created_at) FROM messages m
+	          WHERE m.room_id = rm2.room_id AND m.sender_id = rm2.user_id) NULLS LAST,
+	         rm2.user_id
+) first_member
+WHERE rm.room_id = first_member.room_id
+  AND rm.user_id = first_member.user_id
+  AND NOT EXISTS (SELECT 1 FROM room_members o WHERE o.room_id = rm.room_id AND o.role = 'owner');
+
+CREATE TABLE IF NOT EXISTS room_bans (
+	room_id    INT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+	user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	banned_by  INT REFERENCES users(id) ON DELETE SET NULL,
+	reason     TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (room_id, user_id)
+);
@@ -0,0 +1,17 @@
+-- グループの招待リンク（user-030）
+
+-- 公開グループのみ招待なしで参加できる（デフォルトは招待制）
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS is_public BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS room_invites (
+	id         SERIAL PRIMARY KEY,
+	room_id    INT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+	code       TEXT NOT NULL UNIQUE,
+	created_by INT REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMP,          -- NULL = 無期限
+	max_uses   INT,                -- NULL = 無制限
+	uses       INT NOT NULL DEFAULT 0,
+	revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS room_invites_room_id_idx ON room_invites (room_id);
@@ -0,0 +1,7 @@
+-- ルーム設定：トピック・説明・アイコン（user-031）
+
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS topic TEXT NOT NULL DEFAULT '';
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS avatar_file TEXT;
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW();
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
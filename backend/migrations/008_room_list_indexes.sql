@@ -0,0 +1,6 @@
+-- ルーム一覧（最新メッセージ・未読数）のためのインデックス（user-032）
+
+CREATE INDEX IF NOT EXISTS messages_room_created_idx ON messages (room_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS message_reads_user_idx ON message_reads (user_id, message_id);
+CREATE INDEX IF NOT EXISTS mentions_target_idx ON mentions (mention_target_id, message_id);
+CREATE INDEX IF NOT EXISTS room_members_user_idx ON room_members (user_id);
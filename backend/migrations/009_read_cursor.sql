@@ -0,0 +1,22 @@
+-- ルームごとの既読位置（user-033）
+-- 未読数は message_reads の全件走査ではなく last_read_message_id から計算する
+
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS last_read_message_id INT;
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMP;
+
+-- 既存の message_reads から、ルーム内で読んだ最新メッセージを既読位置とする
+UPDATE room_members rm
+SET last_read_message_id = r.max_message_id,
+    last_read_at = r.max_read_at
+FROM (
+	SELECT m.room_id, mr.user_id, MAX(mr.message_id) AS max_message_id, MAX(mr.read_at) AS max_read_at
+	FROM message_reads mr
+	JOIN messages m ON m.id = mr.message_id
+	GROUP BY m.room_id, mr.user_id
+) r
+WHERE rm.room_id = r.room_id
+  AND rm.user_id = r.user_id
+  AND rm.last_read_message_id IS NULL;
+
+-- 既読者一覧（既読位置 >= メッセージID）の検索用
+CREATE INDEX IF NOT EXISTS room_members_read_cursor_idx ON room_members (room_id, last_read_message_id);
@@ -0,0 +1,4 @@
+-- 手動の未読マーカー（user-034）
+-- 自分の投稿しかないルームでも「未読」として表示できるようにフラグを持つ
+
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS marked_unread BOOLEAN NOT NULL DEFAULT false;
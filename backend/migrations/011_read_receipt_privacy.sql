@@ -0,0 +1,5 @@
+-- 既読表示のプライバシー設定（user-035）
+-- ユーザー単位で既読を他人に見せない／ルーム単位で既読表示そのものを無効にする
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS hide_read_receipts BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS read_receipts_enabled BOOLEAN NOT NULL DEFAULT true;
@@ -0,0 +1,7 @@
+-- ユーザーごとのルーム設定：ミュート・ピン留め・アーカイブ（user-036）
+-- muted_until が NULL のミュートは無期限、archived_at 以降に新しい動きがあれば一覧に再表示する
+
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS muted BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS muted_until TIMESTAMPTZ;
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMPTZ;
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+-- 参加者の組み合わせで識別する DM（user-037）
+-- dm_key はソート済みのユーザーIDをカンマ区切りにしたもの（名前付きグループは NULL）
+
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS dm_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS chat_rooms_dm_key_idx ON chat_rooms (dm_key) WHERE dm_key IS NOT NULL;
@@ -0,0 +1,24 @@
+-- 1対1ルームの識別を参加者の組み合わせに統一（user-038）
+-- 既存の1対1ルームにも dm_key を付け、"user1_user2" 形式のルーム名は使わない
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
+
+-- 同じ2人のルームが複数ある場合は最も古いルームにだけキーを付ける
+UPDATE chat_rooms cr
+SET dm_key = k.dm_key
+FROM (
+	SELECT DISTINCT ON (dm_key) room_id, dm_key
+	FROM (
+		SELECT rm.room_id, MIN(rm.user_id)::text || ',' || MAX(rm.user_id)::text AS dm_key
+		FROM room_members rm
+		JOIN chat_rooms c ON c.id = rm.room_id
+		WHERE c.is_group = false AND c.dm_key IS NULL
+		GROUP BY rm.room_id
+		HAVING COUNT(*) = 2
+	) pairs
+	ORDER BY dm_key, room_id
+) k
+WHERE cr.id = k.room_id
+  AND NOT EXISTS (SELECT 1 FROM chat_rooms d WHERE d.dm_key = k.dm_key);
+
+UPDATE chat_rooms SET room_name = '' WHERE is_group = false;
@@ -0,0 +1,11 @@
+-- ルーム内のピン留めメッセージ（user-039）
+-- メッセージが撤回（削除）されるとピン留めも消える
+
+CREATE TABLE IF NOT EXISTS message_pins (
+	message_id INT PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+	room_id    INT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+	pinned_by  INT REFERENCES users(id) ON DELETE SET NULL,
+	pinned_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS message_pins_room_idx ON message_pins (room_id, pinned_at DESC);
@@ -0,0 +1,16 @@
+-- ユーザーごとの保存メッセージ（ブックマーク）（user-040）
+-- メッセージが撤回（削除）されると保存も消える
+
+CREATE TABLE IF NOT EXISTS saved_messages (
+	id          SERIAL PRIMARY KEY,
+	user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	message_id  INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	note        TEXT NOT NULL DEFAULT '',
+	remind_at   TIMESTAMPTZ,
+	reminded_at TIMESTAMPTZ,
+	created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS saved_messages_user_idx ON saved_messages (user_id, id DESC);
+CREATE INDEX IF NOT EXISTS saved_messages_remind_idx ON saved_messages (remind_at) WHERE reminded_at IS NULL;
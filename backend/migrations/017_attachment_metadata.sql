@@ -0,0 +1,26 @@
+-- 添付ファイルの保存キーと表示名の分離（user-043）
+-- file_name は元のファイル名（表示・ダウンロード用）、storage_key はストレージ上の安全なキー
+
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS storage_key TEXT;
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT 'application/octet-stream';
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS size_bytes BIGINT;
+
+-- 既存の行は file_name が "<UnixNano>_<元のファイル名>" 形式の保存キーになっている
+UPDATE message_attachments
+SET storage_key = file_name,
+    file_name = regexp_replace(file_name, '^[0-9]+_', ''),
+    content_type = CASE lower(substring(file_name from '\.([A-Za-z0-9]+)$'))
+        WHEN 'jpg' THEN 'image/jpeg'
+        WHEN 'jpeg' THEN 'image/jpeg'
+        WHEN 'png' THEN 'image/png'
+        WHEN 'gif' THEN 'image/gif'
+        WHEN 'webp' THEN 'image/webp'
+        WHEN 'pdf' THEN 'application/pdf'
+        WHEN 'txt' THEN 'text/plain'
+        WHEN 'mp4' THEN 'video/mp4'
+        ELSE 'application/octet-stream'
+    END
+WHERE storage_key IS NULL;
+
+ALTER TABLE message_attachments ALTER COLUMN storage_key SET NOT NULL;
+CREATE INDEX IF NOT EXISTS message_attachments_storage_key_idx ON message_attachments (storage_key);
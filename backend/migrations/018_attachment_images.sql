@@ -0,0 +1,7 @@
+-- 画像の寸法とサムネイル（user-044）
+
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS width INT;
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS height INT;
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS thumbnail_key TEXT;
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS thumbnail_width INT;
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS thumbnail_height INT;
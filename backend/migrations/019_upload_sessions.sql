@@ -0,0 +1,26 @@
+-- 再開可能なアップロード（user-046）
+-- チャンクはストレージに個別のオブジェクトとして保存し、完了時に連結して添付ファイルにする
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id            TEXT PRIMARY KEY,
+	user_id       INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	room_id       INT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+	file_name     TEXT NOT NULL DEFAULT '',
+	upload_length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	content_type  TEXT,
+	completing    BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expires_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS upload_sessions_user_idx ON upload_sessions (user_id);
+CREATE INDEX IF NOT EXISTS upload_sessions_expires_idx ON upload_sessions (expires_at);
+
+CREATE TABLE IF NOT EXISTS upload_chunks (
+	upload_id    TEXT NOT NULL REFERENCES upload_sessions(id) ON DELETE CASCADE,
+	chunk_offset BIGINT NOT NULL,
+	size         BIGINT NOT NULL,
+	storage_key  TEXT NOT NULL,
+	PRIMARY KEY (upload_id, chunk_offset)
+);
@@ -0,0 +1,18 @@
+-- 内容アドレスによる添付ファイルの重複排除（user-047）
+-- 同じ内容のファイルは1つの blob を共有し、参照しているメッセージがなくなったら削除する
+
+CREATE TABLE IF NOT EXISTS attachment_blobs (
+	storage_key   TEXT PRIMARY KEY,
+	content_type  TEXT NOT NULL,
+	size_bytes    BIGINT,
+	thumbnail_key TEXT,
+	ref_count     INT NOT NULL DEFAULT 0,
+	created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 既存の添付ファイルは（ハッシュではない）従来のキーのまま登録する
+INSERT INTO attachment_blobs (storage_key, content_type, size_bytes, thumbnail_key, ref_count)
+SELECT storage_key, MIN(content_type), MAX(size_bytes), MAX(thumbnail_key), COUNT(*)
+FROM message_attachments
+GROUP BY storage_key
+ON CONFLICT (storage_key) DO NOTHING;
@@ -0,0 +1,5 @@
+-- 保存容量の集計（user-048）
+-- ユーザーごとの使用量は送信者のメッセージの添付ファイルから集計する
+
+CREATE INDEX IF NOT EXISTS messages_sender_idx ON messages (sender_id);
+CREATE INDEX IF NOT EXISTS message_attachments_message_idx ON message_attachments (message_id);
@@ -0,0 +1,14 @@
+-- マルウェアが検出された添付ファイルの隔離（user-049）
+-- ファイルは quarantine/ 配下に保存し、署名付き URL は発行しない
+
+CREATE TABLE IF NOT EXISTS quarantined_attachments (
+	id           SERIAL PRIMARY KEY,
+	user_id      INT REFERENCES users(id) ON DELETE SET NULL,
+	room_id      INT REFERENCES chat_rooms(id) ON DELETE SET NULL,
+	file_name    TEXT NOT NULL,
+	storage_key  TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size_bytes   BIGINT,
+	signature    TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
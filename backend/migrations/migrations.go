@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Run は未適用の SQL マイグレーションをファイル名順に適用する
+// 適用済みのバージョンは schema_migrations テーブルに記録される
+func Run(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    TEXT PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("schema_migrations の作成に失敗: %w", err)
+	}
+
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names) // 001_, 002_ ... の順に適用
+
+	for _, name := range names {
+		var applied bool
+		err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, name).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("マイグレーション状態の取得に失敗 (%s): %w", name, err)
+		}
+		if applied {
+			continue
+		}
+
+		body, err := files.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		// 1ファイル = 1トランザクション
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(body)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("マイグレーション適用失敗 (%s): %w", name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("マイグレーション記録失敗 (%s): %w", name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Println("✅ マイグレーション適用:", name)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDC プロバイダーの接続設定
+type Config struct {
+	Issuer       string // 例: https://idp.example.com/realms/company
+	ClientID     string
+	ClientSecret string   // パブリッククライアントの場合は空
+	RedirectURL  string   // 例: http://localhost:8081/auth/oidc/callback
+	Scopes       []string // openid は自動で付与される
+
+	// ログイン後のユーザー紐付け方針
+	LinkByEmail   bool   // 検証済みメールが一致する既存ユーザーに紐付ける（IdP 側でメールを自由に設定できる場合は乗っ取りにつながるため既定は無効）
+	AutoProvision bool   // 該当ユーザーがいなければ自動作成する
+	PostLoginURL  string // ログイン完了後のリダイレクト先（フロントエンド）
+}
+
+// ✅ 環境変数から設定を読み込む（OIDC_ISSUER が未設定なら nil）
+// OIDC_ISSUER / OIDC_CLIENT_ID / OIDC_CLIENT_SECRET / OIDC_REDIRECT_URL / OIDC_SCOPES /
+// OIDC_LINK_BY_EMAIL / OIDC_AUTO_PROVISION / OIDC_POST_LOGIN_URL
+func ConfigFromEnv() *Config {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	scopes := []string{"profile", "email"}
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	postLogin := os.Getenv("OIDC_POST_LOGIN_URL")
+	if postLogin == "" {
+		postLogin = "http://localhost:3001/chatroom"
+	}
+	return &Config{
+		Issuer:        issuer,
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        scopes,
+		LinkByEmail:   envBool("OIDC_LINK_BY_EMAIL", false),
+		AutoProvision: envBool("OIDC_AUTO_PROVISION", true),
+		PostLoginURL:  postLogin,
+	}
+}
+
+// Config は読み込まれた設定を返す
+func (p *Provider) Config() Config {
+	return p.cfg
+}
+
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// ディスカバリードキュメント（.well-known/openid-configuration）の必要項目
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider はディスカバリー結果と署名鍵（JWKS）を保持する
+type Provider struct {
+	cfg    Config
+	meta   discovery
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]any // kid -> 公開鍵
+	keysAt  time.Time
+	keysTTL time.Duration
+}
+
+// ID トークンから取り出すユーザー情報
+type IDTokenClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Nonce             string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// ✅ ディスカバリーを実行して Provider を作成
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	p := &Provider{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		keysTTL: time.Hour,
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &p.meta); err != nil {
+		return nil, fmt.Errorf("ディスカバリー取得失敗: %w", err)
+	}
+	if strings.TrimSuffix(p.meta.Issuer, "/") != strings.TrimSuffix(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("issuer が一致しません: %s", p.meta.Issuer)
+	}
+	if p.meta.AuthorizationEndpoint == "" || p.meta.TokenEndpoint == "" || p.meta.JWKSURI == "" {
+		return nil, errors.New("ディスカバリードキュメントに必要な項目がありません")
+	}
+	return p, nil
+}
+
+// Issuer は検証済みの issuer を返す（user_identities の保存に使用）
+func (p *Provider) Issuer() string {
+	return p.meta.Issuer
+}
+
+// ✅ PKCE 用の code_verifier から S256 の code_challenge を作成
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ✅ 認可エンドポイントへのリダイレクト URL を作成（authorization code + PKCE）
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	scopes := append([]string{"openid"}, p.cfg.Scopes...)
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallengeS256(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.meta.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// ✅ 認可コードをトークンに交換し、ID トークンを検証してクレームを返す
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*IDTokenClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("トークンエンドポイント呼び出し失敗: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("トークンレスポンスの解析失敗: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.Error != "" {
+		return nil, fmt.Errorf("トークン交換失敗: %s %s", tok.Error, tok.ErrorDescription)
+	}
+	if tok.IDToken == "" {
+		return nil, errors.New("id_token が含まれていません")
+	}
+
+	return p.VerifyIDToken(ctx, tok.IDToken, nonce)
+}
+
+// ✅ ID トークンの署名・issuer・audience・有効期限・nonce を検証
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ID トークンの検証失敗: %w", err)
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("nonce が一致しません")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("sub が含まれていません")
+	}
+	return claims, nil
+}
+
+// kid に対応する公開鍵を返す（見つからない場合は JWKS を再取得）
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys == nil || time.Since(p.keysAt) > p.keysTTL || (kid != "" && p.keys[kid] == nil) {
+		keys, err := p.fetchJWKS(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.keys, p.keysAt = keys, time.Now()
+	}
+
+	if kid != "" {
+		if k, ok := p.keys[kid]; ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("署名鍵が見つかりません: %s", kid)
+	}
+	// kid なしのトークンは鍵が1つの場合のみ許可
+	if len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, nil
+		}
+	}
+	return nil, errors.New("署名鍵を特定できません")
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) fetchJWKS(ctx context.Context) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("JWKS 取得失敗: %w", err)
+	}
+
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue // 未対応の鍵はスキップ
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("利用可能な署名鍵がありません")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("未対応の曲線: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("未対応の鍵タイプ: %s", k.Kty)
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: ステータス %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,111 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ClamAV は clamd の INSTREAM コマンドでファイルを検査する
+// Network は "unix" または "tcp"
+type ClamAV struct {
+	Network   string
+	Address   string
+	Timeout   time.Duration
+	ChunkSize int
+}
+
+// addr の例: "unix:/var/run/clamav/clamd.ctl", "/var/run/clamav/clamd.ctl", "tcp://127.0.0.1:3310", "127.0.0.1:3310"
+func NewClamAV(addr string) (*ClamAV, error) {
+	c := &ClamAV{Timeout: 30 * time.Second, ChunkSize: 64 << 10}
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		c.Network, c.Address = "unix", strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+	case strings.HasPrefix(addr, "tcp://"):
+		c.Network, c.Address = "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "/"):
+		c.Network, c.Address = "unix", addr
+	default:
+		c.Network, c.Address = "tcp", addr
+	}
+	if c.Address == "" {
+		return nil, fmt.Errorf("scanner: invalid clamd address %q", addr)
+	}
+	return c, nil
+}
+
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	d := net.Dialer{Timeout: c.Timeout}
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.Timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	// "z" 付きのコマンドは NUL 区切り
+	// データは「4バイトのビッグエンディアン長 + 本体」のチャンクで送り、長さ 0 で終わる
+	writeErr := c.stream(conn, r)
+
+	// サイズ上限などで clamd が途中で切断した場合も、先に返された応答を読む
+	resp, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && resp == "" {
+		if writeErr != nil {
+			return Result{}, writeErr
+		}
+		return Result{}, err
+	}
+	return parseClamdResponse(resp)
+}
+
+func (c *ClamAV) stream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+	size := c.ChunkSize
+	if size <= 0 {
+		size = 64 << 10
+	}
+	buf := make([]byte, 4+size)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// 応答の例: "stream: OK", "stream: Eicar-Test-Signature FOUND", "INSTREAM size limit exceeded. ERROR"
+func parseClamdResponse(resp string) (Result, error) {
+	resp = strings.TrimSpace(strings.TrimRight(resp, "\x00"))
+	msg := strings.TrimPrefix(resp, "stream: ")
+	switch {
+	case msg == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(msg, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("%w: %s", ErrScanFailed, resp)
+	}
+}
@@ -0,0 +1,230 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"
+
+// clamd の INSTREAM を真似するテスト用サーバー
+type fakeClamd struct {
+	ln      net.Listener
+	limit   int      // 受け付ける最大サイズ（0 = 無制限）
+	reply   string   // 固定の応答（空なら内容から判定）
+	chunks  chan int // 受け取ったチャンク数
+	command chan string
+}
+
+func startFakeClamd(t *testing.T, network, address string) *fakeClamd {
+	t.Helper()
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeClamd{ln: ln, chunks: make(chan int, 1), command: make(chan string, 1)}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	f.command <- cmd
+
+	var data bytes.Buffer
+	chunks := 0
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		chunks++
+		if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+			return
+		}
+		if f.limit > 0 && data.Len() > f.limit {
+			io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			f.chunks <- chunks
+			return
+		}
+	}
+	f.chunks <- chunks
+
+	switch {
+	case f.reply != "":
+		io.WriteString(conn, f.reply+"\x00")
+	case strings.Contains(data.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE"):
+		io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+	default:
+		io.WriteString(conn, "stream: OK\x00")
+	}
+}
+
+func newTestClamAV(t *testing.T, f *fakeClamd) *ClamAV {
+	t.Helper()
+	addr := f.ln.Addr()
+	return &ClamAV{Network: addr.Network(), Address: addr.String(), Timeout: 5 * time.Second, ChunkSize: 64 << 10}
+}
+
+func TestClamAVScanClean(t *testing.T) {
+	f := startFakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"))
+	c := newTestClamAV(t, f)
+
+	res, err := c.Scan(context.Background(), strings.NewReader("hello, world"))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if res.Infected {
+		t.Fatalf("Scan: infected = true, want false (signature %q)", res.Signature)
+	}
+	if cmd := <-f.command; cmd != "zINSTREAM\x00" {
+		t.Errorf("command = %q, want %q", cmd, "zINSTREAM\x00")
+	}
+}
+
+func TestClamAVScanFound(t *testing.T) {
+	f := startFakeClamd(t, "tcp", "127.0.0.1:0")
+	c := newTestClamAV(t, f)
+
+	res, err := c.Scan(context.Background(), strings.NewReader(eicar))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !res.Infected || res.Signature != "Eicar-Test-Signature" {
+		t.Fatalf("Scan = %+v, want infected with Eicar-Test-Signature", res)
+	}
+}
+
+func TestClamAVScanErrorReply(t *testing.T) {
+	f := startFakeClamd(t, "tcp", "127.0.0.1:0")
+	f.limit = 16
+	c := newTestClamAV(t, f)
+	c.ChunkSize = 8
+
+	_, err := c.Scan(context.Background(), strings.NewReader(strings.Repeat("a", 1024)))
+	if !errors.Is(err, ErrScanFailed) {
+		t.Fatalf("Scan error = %v, want ErrScanFailed", err)
+	}
+}
+
+func TestClamAVScanUnexpectedReply(t *testing.T) {
+	f := startFakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"))
+	f.reply = "UNKNOWN COMMAND"
+	c := newTestClamAV(t, f)
+
+	_, err := c.Scan(context.Background(), strings.NewReader("data"))
+	if !errors.Is(err, ErrScanFailed) {
+		t.Fatalf("Scan error = %v, want ErrScanFailed", err)
+	}
+}
+
+func TestClamAVScanChunked(t *testing.T) {
+	f := startFakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"))
+	c := newTestClamAV(t, f)
+	c.ChunkSize = 7
+
+	// シグネチャがチャンクの境界をまたいでも検出される
+	res, err := c.Scan(context.Background(), strings.NewReader("prefix-"+eicar+"-suffix"))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !res.Infected {
+		t.Fatalf("Scan: infected = false, want true")
+	}
+	want := (len("prefix-"+eicar+"-suffix") + 6) / 7
+	if got := <-f.chunks; got != want {
+		t.Errorf("chunks = %d, want %d", got, want)
+	}
+}
+
+func TestClamAVScanUnreachable(t *testing.T) {
+	c := &ClamAV{Network: "unix", Address: filepath.Join(t.TempDir(), "missing.sock"), Timeout: time.Second}
+	if _, err := c.Scan(context.Background(), strings.NewReader("data")); err == nil {
+		t.Fatal("Scan: err = nil, want connection error")
+	}
+}
+
+func TestParseClamdResponse(t *testing.T) {
+	tests := []struct {
+		resp      string
+		infected  bool
+		signature string
+		wantErr   bool
+	}{
+		{resp: "stream: OK\x00"},
+		{resp: "stream: OK\n"},
+		{resp: "stream: Eicar-Test-Signature FOUND\x00", infected: true, signature: "Eicar-Test-Signature"},
+		{resp: "stream: Win.Test.EICAR_HDB-1 FOUND", infected: true, signature: "Win.Test.EICAR_HDB-1"},
+		{resp: "INSTREAM size limit exceeded. ERROR\x00", wantErr: true},
+		{resp: "UNKNOWN COMMAND\x00", wantErr: true},
+		{resp: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		res, err := parseClamdResponse(tt.resp)
+		if tt.wantErr {
+			if !errors.Is(err, ErrScanFailed) {
+				t.Errorf("parseClamdResponse(%q) error = %v, want ErrScanFailed", tt.resp, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseClamdResponse(%q) error = %v", tt.resp, err)
+			continue
+		}
+		if res.Infected != tt.infected || res.Signature != tt.signature {
+			t.Errorf("parseClamdResponse(%q) = %+v, want infected=%v signature=%q", tt.resp, res, tt.infected, tt.signature)
+		}
+	}
+}
+
+func TestNewClamAV(t *testing.T) {
+	tests := []struct {
+		addr, network, address string
+	}{
+		{"unix:/var/run/clamav/clamd.ctl", "unix", "/var/run/clamav/clamd.ctl"},
+		{"/var/run/clamav/clamd.ctl", "unix", "/var/run/clamav/clamd.ctl"},
+		{"tcp://127.0.0.1:3310", "tcp", "127.0.0.1:3310"},
+		{"clamd:3310", "tcp", "clamd:3310"},
+	}
+	for _, tt := range tests {
+		c, err := NewClamAV(tt.addr)
+		if err != nil {
+			t.Errorf("NewClamAV(%q): %v", tt.addr, err)
+			continue
+		}
+		if c.Network != tt.network || c.Address != tt.address {
+			t.Errorf("NewClamAV(%q) = %s %s, want %s %s", tt.addr, c.Network, c.Address, tt.network, tt.address)
+		}
+	}
+	if _, err := NewClamAV("tcp://"); err == nil {
+		t.Error("NewClamAV(\"tcp://\"): err = nil, want error")
+	}
+}
@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// スキャン結果
+type Result struct {
+	Infected  bool
+	Signature string // 検出されたマルウェア名（Infected のときのみ）
+}
+
+// 添付ファイルを公開する前に内容を検査するインターフェース
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// スキャナーがファイルを検査できなかった（サイズ超過など）
+var ErrScanFailed = errors.New("scanner: scan failed")
+
+// ✅ 環境変数 SCANNER_BACKEND に応じて Scanner を生成する
+//
+//	none / 未設定 : スキャンしない（nil を返す）
+//	clamav        : CLAMD_ADDRESS（例 "unix:/var/run/clamav/clamd.ctl"、"tcp://127.0.0.1:3310"）の clamd に送る
+//	                CLAMD_TIMEOUT（デフォルト 30s）
+func NewFromEnv() (Scanner, error) {
+	switch backend := os.Getenv("SCANNER_BACKEND"); backend {
+	case "", "none":
+		return nil, nil
+	case "clamav":
+		addr := os.Getenv("CLAMD_ADDRESS")
+		if addr == "" {
+			addr = "tcp://127.0.0.1:3310"
+		}
+		c, err := NewClamAV(addr)
+		if err != nil {
+			return nil, err
+		}
+		if v := os.Getenv("CLAMD_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("scanner: invalid CLAMD_TIMEOUT %q", v)
+			}
+			c.Timeout = d
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("scanner: unknown SCANNER_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// 複数のオブジェクトを順番に連結して読み出す Reader
+// （再開可能アップロードのチャンクを1つのファイルとして扱うため）
+// オブジェクトは読み進めるたびに1つずつ開くので、同時に開くのは1つだけ
+type concatReader struct {
+	ctx  context.Context
+	s    Storage
+	keys []string
+	cur  io.ReadCloser
+}
+
+// ✅ keys の順にオブジェクトを連結した Reader を返す
+func NewConcatReader(ctx context.Context, s Storage, keys []string) io.ReadCloser {
+	return &concatReader{ctx: ctx, s: s, keys: keys}
+}
+
+func (c *concatReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.keys) == 0 {
+				return 0, io.EOF
+			}
+			rc, _, err := c.s.Get(c.ctx, c.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			c.cur, c.keys = rc, c.keys[1:]
+		}
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *concatReader) Close() error {
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// シーク可能なオブジェクトの Reader（HTTP の Range リクエスト用）
+// Seek ではストレージにアクセスせず、Read のときに現在位置から末尾までを GetRange で取得する。
+// 連続した読み込みは1回の取得で済み、位置が変わったときだけ取り直す。
+type ObjectReader struct {
+	ctx  context.Context
+	s    Storage
+	key  string
+	size int64
+	pos  int64
+
+	rc    io.ReadCloser
+	rcPos int64
+}
+
+// ✅ key のオブジェクトを io.ReadSeeker として開く（http.ServeContent に渡せる）
+func Open(ctx context.Context, s Storage, key string) (*ObjectReader, ObjectInfo, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	if info.Size < 0 {
+		return nil, ObjectInfo{}, errors.New("storage: object size unknown")
+	}
+	return &ObjectReader{ctx: ctx, s: s, key: key, size: info.Size}, info, nil
+}
+
+func (o *ObjectReader) Read(p []byte) (int, error) {
+	if o.rc != nil && o.rcPos != o.pos {
+		o.rc.Close()
+		o.rc = nil
+	}
+	if o.pos >= o.size {
+		return 0, io.EOF
+	}
+	if o.rc == nil {
+		rc, err := o.s.GetRange(o.ctx, o.key, o.pos, -1)
+		if err != nil {
+			return 0, err
+		}
+		o.rc, o.rcPos = rc, o.pos
+	}
+	n, err := o.rc.Read(p)
+	o.pos += int64(n)
+	o.rcPos += int64(n)
+	if err == io.EOF && o.pos < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.pos + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	o.pos = abs
+	return abs, nil
+}
+
+func (o *ObjectReader) Close() error {
+	if o.rc != nil {
+		return o.rc.Close()
+	}
+	return nil
+}
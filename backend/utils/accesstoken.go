@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// パーソナルアクセストークンの接頭辞（JWT と区別するため）
+const AccessTokenPrefix = "cat_"
+
+// トークンのスコープ
+const (
+	ScopeRead         = "read"          // GET などの参照系のみ
+	ScopeMessagesPost = "messages:post" // メッセージ送信・添付アップロード
+	ScopeWrite        = "write"         // すべての操作（トークン管理・パスワード変更を除く）
+)
+
+// 指定可能なスコープ一覧
+var ValidScopes = map[string]bool{
+	ScopeRead:         true,
+	ScopeMessagesPost: true,
+	ScopeWrite:        true,
+}
+
+// 認証済みリクエストの情報（ミドルウェアがコンテキストに格納する）
+type AuthInfo struct {
+	UserID    int
+	SessionID string   // JWT ログインの場合
+	TokenID   int      // アクセストークンの場合（JWT なら 0）
+	Scopes    []string // アクセストークンのスコープ
+	RoomID    *int     // 投稿先ルームの制限
+}
+
+type authContextKey struct{}
+
+// ✅ 認証情報をコンテキストに格納
+func WithAuth(ctx context.Context, info *AuthInfo) context.Context {
+	return context.WithValue(ctx, authContextKey{}, info)
+}
+
+// ✅ コンテキストから認証情報を取得（未認証なら nil）
+func AuthFromRequest(r *http.Request) *AuthInfo {
+	info, _ := r.Context().Value(authContextKey{}).(*AuthInfo)
+	return info
+}
+
+// アクセストークンで認証されたリクエストか
+func (a *AuthInfo) IsAccessToken() bool {
+	return a != nil && a.TokenID != 0
+}
+
+// ✅ スコープを持っているか（write はすべてを含む、JWT ログインは常に true）
+func (a *AuthInfo) HasScope(scope string) bool {
+	if a == nil || !a.IsAccessToken() {
+		return true
+	}
+	for _, s := range a.Scopes {
+		if s == ScopeWrite || s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ✅ トークンのルーム制限に対してルームが許可されているか
+func (a *AuthInfo) AllowsRoom(roomID int) bool {
+	return a == nil || a.RoomID == nil || *a.RoomID == roomID
+}
+
+// ✅ メソッドとパスから必要なスコープを判定
+func RequiredScope(r *http.Request) string {
+	// 再開可能アップロード（作成・チャンク送信・進捗確認・中止・完了）は添付アップロードの一部
+	if r.URL.Path == "/resumable-uploads" || strings.HasPrefix(r.URL.Path, "/resumable-uploads/") {
+		return ScopeMessagesPost
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	}
+	if r.Method == http.MethodPost && (r.URL.Path == "/messages" || r.URL.Path == "/messages/upload") {
+		return ScopeMessagesPost
+	}
+	return ScopeWrite
+}
+
+// ✅ Authorization ヘッダーのアクセストークンを取り出す（なければ空文字）
+func AccessTokenFromRequest(r *http.Request) string {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if strings.HasPrefix(token, AccessTokenPrefix) {
+		return token
+	}
+	return ""
+}
+
+// ✅ 新しいアクセストークン文字列を生成
+func NewAccessToken() (string, error) {
+	random, err := NewRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	return AccessTokenPrefix + random, nil
+}
+
+// ✅ アクセストークンを検証して認証情報を返す（last_used_at も更新）
+func LookupAccessToken(db *sql.DB, token string) (*AuthInfo, error) {
+	info := &AuthInfo{}
+	var roomID sql.NullInt64
+	var scopes pq.StringArray
+	err := db.QueryRow(`
+		SELECT id, user_id, scopes, room_id
+		FROM personal_access_tokens
+		WHERE token_hash = $1
+		  AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+	`, HashToken(token)).Scan(&info.TokenID, &info.UserID, &scopes, &roomID)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("アクセストークンが無効です")
+	} else if err != nil {
+		return nil, err
+	}
+	info.Scopes = scopes
+	if roomID.Valid {
+		id := int(roomID.Int64)
+		info.RoomID = &id
+	}
+
+	// 毎回書き込まないよう 1 分単位で更新
+	_, _ = db.Exec(`
+		UPDATE personal_access_tokens SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`, info.TokenID)
+
+	return info, nil
+}
@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 添付ファイルの受け入れルール（形式の許可リストと形式ごとのサイズ上限）
+type AttachmentPolicy struct {
+	// 許可する MIME タイプ（"image/*" のようなワイルドカード可）
+	AllowedTypes []string
+	// MIME タイプ（またはワイルドカード）ごとのサイズ上限（バイト）
+	SizeLimits map[string]int64
+	// SizeLimits に該当しない形式のサイズ上限
+	DefaultMaxSize int64
+	// ユーザーごと・ルームごとの添付ファイルの合計サイズの上限（0 は無制限）
+	UserQuota int64
+	RoomQuota int64
+}
+
+// デフォルトのルール（環境変数で上書き可能）
+var DefaultAttachmentPolicy = AttachmentPolicy{
+	AllowedTypes: []string{
+		"image/png", "image/jpeg", "image/gif", "image/webp",
+		"video/mp4", "video/webm", "audio/mpeg", "audio/wave", "audio/ogg",
+		"application/pdf", "application/zip", "text/plain",
+	},
+	SizeLimits: map[string]int64{
+		"image/*": 10 << 20,
+		"video/*": 100 << 20,
+		"audio/*": 20 << 20,
+	},
+	DefaultMaxSize: 20 << 20,
+	UserQuota:      1 << 30,
+	RoomQuota:      10 << 30,
+}
+
+// ✅ 環境変数から添付ファイルのルールを読み込む
+// ATTACHMENT_ALLOWED_TYPES : 例 "image/*,application/pdf"
+// ATTACHMENT_SIZE_LIMITS   : 例 "image/*=10MB,video/*=200MB,*=20MB"（"*" はその他すべて）
+// ATTACHMENT_USER_QUOTA    : 例 "2GB"（"0" で無制限）
+// ATTACHMENT_ROOM_QUOTA    : 例 "20GB"（"0" で無制限）
+func AttachmentPolicyFromEnv() AttachmentPolicy {
+	p := DefaultAttachmentPolicy
+	if v := os.Getenv("ATTACHMENT_ALLOWED_TYPES"); v != "" {
+		p.AllowedTypes = nil
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				p.AllowedTypes = append(p.AllowedTypes, t)
+			}
+		}
+	}
+	if v := os.Getenv("ATTACHMENT_SIZE_LIMITS"); v != "" {
+		p.SizeLimits = map[string]int64{}
+		for _, entry := range strings.Split(v, ",") {
+			pattern, size, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				continue
+			}
+			n, err := ParseByteSize(size)
+			if err != nil {
+				continue
+			}
+			if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern == "*" {
+				p.DefaultMaxSize = n
+			} else {
+				p.SizeLimits[pattern] = n
+			}
+		}
+	}
+	p.UserQuota = quotaFromEnv("ATTACHMENT_USER_QUOTA", p.UserQuota)
+	p.RoomQuota = quotaFromEnv("ATTACHMENT_ROOM_QUOTA", p.RoomQuota)
+	return p
+}
+
+func quotaFromEnv(name string, def int64) int64 {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	if v == "0" {
+		return 0
+	}
+	n, err := ParseByteSize(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// "10MB" / "512KB" / "1GB" / "1024" をバイト数に変換
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+func matchMIME(pattern, contentType string) bool {
+	if pattern == "*" || pattern == "*/*" || pattern == contentType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(contentType, prefix+"/")
+	}
+	return false
+}
+
+// 形式が許可リストに含まれるか
+func (p AttachmentPolicy) Allows(contentType string) bool {
+	for _, pattern := range p.AllowedTypes {
+		if matchMIME(pattern, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// 形式ごとのサイズ上限（完全一致 → ワイルドカード → デフォルトの順）
+func (p AttachmentPolicy) MaxSize(contentType string) int64 {
+	if n, ok := p.SizeLimits[contentType]; ok {
+		return n
+	}
+	best, bestLen := p.DefaultMaxSize, -1
+	for pattern, n := range p.SizeLimits {
+		if matchMIME(pattern, contentType) && len(pattern) > bestLen {
+			best, bestLen = n, len(pattern)
+		}
+	}
+	return best
+}
+
+// リクエスト全体で受け付ける最大サイズ（いずれかの形式の上限の最大値）
+func (p AttachmentPolicy) MaxUploadSize() int64 {
+	max := p.DefaultMaxSize
+	for _, n := range p.SizeLimits {
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// ✅ ファイル先頭の内容から MIME タイプを判定する（パラメータは除く）
+func SniffContentType(head []byte) string {
+	ct, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if ct == "" {
+		return "application/octet-stream"
+	}
+	return ct
+}
+
+// 判定した形式に対応する拡張子（保存キー用）
+var contentTypeExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"audio/wave":      ".wav",
+	"audio/ogg":       ".ogg",
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+	"text/plain":      ".txt",
+}
+
+// ✅ 表示用のファイル名を安全な形に整える（パス・制御文字を取り除き、長さを制限）
+func SanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(strings.Trim(name, "."))
+	if runes := []rune(name); len(runes) > 200 {
+		ext := path.Ext(name)
+		if utf8.RuneCountInString(ext) > 20 {
+			ext = ""
+		}
+		name = string(runes[:200-utf8.RuneCountInString(ext)]) + ext
+	}
+	if name == "" || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// ✅ 内容の SHA-256 から添付ファイルの保存キーを生成する（元のファイル名は使わない）
+// 同じ内容のファイルは同じキーになり、ストレージ上で共有される
+// 例: attachments/sha256/3f/3f2a...c1.jpg
+func ContentAttachmentKey(sum []byte, contentType string) string {
+	h := hex.EncodeToString(sum)
+	return "attachments/sha256/" + h[:2] + "/" + h + contentTypeExtensions[contentType]
+}
+
+// ✅ 内容アドレスのキー（attachments/sha256/…、そのサムネイル thumbnails/sha256/…）なら
+// 内容が変わらないので、キーから強い ETag を決められる
+func ContentETag(key string) (string, bool) {
+	for _, prefix := range []string{"attachments/sha256/", "thumbnails/sha256/"} {
+		if rest, ok := strings.CutPrefix(key, prefix); ok {
+			name := path.Base(rest)
+			h := strings.TrimSuffix(name, path.Ext(name))
+			if len(h) != 64 || strings.Trim(h, "0123456789abcdef") != "" {
+				return "", false
+			}
+			if prefix == "thumbnails/sha256/" {
+				return `"sha256-` + h + `-thumb"`, true
+			}
+			return `"sha256-` + h + `"`, true
+		}
+	}
+	return "", false
+}
+
+// ✅ Content-Disposition ヘッダー値（日本語・中国語のファイル名は RFC 5987 の filename* で渡す）
+func ContentDisposition(disposition, fileName string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, fileName)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, ascii, rfc5987Escape(fileName))
+}
+
+// RFC 5987 の attr-char 以外をパーセントエンコードする
+func rfc5987Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// ブラウザ内で表示してよい形式（それ以外は常にダウンロードさせる）
+func InlineSafe(contentType string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml":
+		return true
+	case strings.HasPrefix(contentType, "video/"), strings.HasPrefix(contentType, "audio/"):
+		return true
+	case contentType == "application/pdf", contentType == "text/plain":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,11 @@
+package utils
+
+import "os"
+
+// ✅ フロントエンドのベース URL（メール・招待リンクの生成に使用）
+func AppBaseURL() string {
+	if v := os.Getenv("APP_BASE_URL"); v != "" {
+		return v
+	}
+	return "http://localhost:3001"
+}
@@ -16,16 +16,13 @@ var jwtSecret = []byte("your-secret-key")
 
 // ✅ JWT トークンから user_id を取得（優先順位：Cookie → Authorization ヘッダー）
 func GetUserIDFromToken(r *http.Request) (int, error) {
-	// ✅ Cookie から token を取得（推奨）
-	cookie, err := r.Cookie("token")
-	var tokenStr string
-	if err == nil {
-		tokenStr = cookie.Value
-	} else {
-		// ⚠️ Fallback: Authorization ヘッダーから取得（例：WebSocket 接続時）
-		tokenStr = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	// ✅ ミドルウェアで認証済み（アクセストークン含む）の場合はその結果を使う
+	if info := AuthFromRequest(r); info != nil {
+		return info.UserID, nil
 	}
 
+	tokenStr := TokenFromRequest(r)
+
 	// トークン解析
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		return jwtSecret, nil
@@ -48,6 +45,21 @@ func GetUserIDFromToken(r *http.Request) (int, error) {
 	return int(idFloat), nil // 最後に int を返す
 }
 
+// ✅ リクエストからトークン文字列を取り出す（Cookie → Authorization ヘッダー）
+func TokenFromRequest(r *http.Request) string {
+	// ✅ Cookie から token を取得（推奨）
+	if cookie, err := r.Cookie("token"); err == nil {
+		return cookie.Value
+	}
+	// ⚠️ Fallback: Authorization ヘッダーから取得（例：WebSocket 接続時）
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// ✅ リクエストのトークンを検証してクレームを返す（セッションIDが必要な場合に使用）
+func GetClaimsFromRequest(r *http.Request) (*Claims, error) {
+	return ValidateJWT(TokenFromRequest(r))
+}
+
 // シグネチャとバリデーションのための JWT キー（署名鍵）
 var jwtKey = []byte("your-secret-key")
 
@@ -58,13 +70,17 @@ type Claims struct {
 	jwt.RegisteredClaims        //// JWT 標準項目のセット（推奨）
 }
 
-// ✅ JWT トークンを生成（userID + username）
-func GenerateJWT(userID int, username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // 有効期限：24時間
+// ✅ JWT トークンを生成（userID + username + セッションID）
+// セッションIDは jti に格納され、ミドルウェアで失効チェックに使われる
+func GenerateJWT(userID int, username string, sessionID string) (string, error) {
+	now := time.Now()
+	expirationTime := now.Add(SessionTTL) // 有効期限：24時間
 	claims := &Claims{
 		UserID:   userID, //////////
 		Username: username,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
+			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			// time.Time を JWT 用の NumericDate に変換して ExpiresAt に代入
 		},
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// パスワード強度ルール（サインアップ・変更・リセット時に適用）
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// ユーザー名を含むパスワードを拒否する
+	DisallowUsername bool
+}
+
+// デフォルトのルール（環境変数で上書き可能）
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:        8,
+	MaxLength:        72, // bcrypt は 72 バイトまでしか扱えない
+	RequireLower:     false,
+	RequireUpper:     false,
+	RequireDigit:     true,
+	RequireSymbol:    false,
+	DisallowUsername: true,
+}
+
+// ✅ 環境変数からパスワードルールを読み込む
+// PASSWORD_MIN_LENGTH / PASSWORD_REQUIRE_UPPER / PASSWORD_REQUIRE_LOWER /
+// PASSWORD_REQUIRE_DIGIT / PASSWORD_REQUIRE_SYMBOL / PASSWORD_DISALLOW_USERNAME
+func PasswordPolicyFromEnv() PasswordPolicy {
+	p := DefaultPasswordPolicy
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && v > 0 {
+		p.MinLength = v
+	}
+	p.RequireUpper = envBool("PASSWORD_REQUIRE_UPPER", p.RequireUpper)
+	p.RequireLower = envBool("PASSWORD_REQUIRE_LOWER", p.RequireLower)
+	p.RequireDigit = envBool("PASSWORD_REQUIRE_DIGIT", p.RequireDigit)
+	p.RequireSymbol = envBool("PASSWORD_REQUIRE_SYMBOL", p.RequireSymbol)
+	p.DisallowUsername = envBool("PASSWORD_DISALLOW_USERNAME", p.DisallowUsername)
+	return p
+}
+
+// ✅ パスワードがルールを満たしているか検証（満たさない場合は理由をエラーで返す）
+func (p PasswordPolicy) Validate(password, username string) error {
+	if strings.TrimSpace(password) == "" {
+		return errors.New("パスワードを入力してください") // 密碼不可為空
+	}
+	if len([]rune(password)) < p.MinLength {
+		return errors.New("パスワードは " + strconv.Itoa(p.MinLength) + " 文字以上にしてください")
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return errors.New("パスワードが長すぎます")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		return errors.New("パスワードに大文字を含めてください")
+	}
+	if p.RequireLower && !hasLower {
+		return errors.New("パスワードに小文字を含めてください")
+	}
+	if p.RequireDigit && !hasDigit {
+		return errors.New("パスワードに数字を含めてください")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return errors.New("パスワードに記号を含めてください")
+	}
+	if p.DisallowUsername && username != "" &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("パスワードにユーザー名を含めることはできません")
+	}
+	return nil
+}
+
+// 環境変数を bool として読み込む（未設定・不正値はデフォルト）
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// セッション（＝JWT）の有効期間
+const SessionTTL = 24 * time.Hour
+
+// ✅ 推測不可能なランダムトークンを生成（URL セーフな base64）
+func NewRandomToken(nBytes int) (string, error) {
+	b := make([]byte, nBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ✅ トークンを DB 保存用にハッシュ化（平文は保存しない）
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ✅ 新しいログインセッションを作成し、そのIDを返す
+func CreateSession(db *sql.DB, userID int) (string, error) {
+	sessionID, err := NewRandomToken(24)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`
+		INSERT INTO sessions (id, user_id, expires_at)
+		VALUES ($1, $2, $3)
+	`, sessionID, userID, time.Now().Add(SessionTTL))
+	if err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// ✅ セッションが失効していないか確認
+func IsSessionActive(db *sql.DB, sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+	var active bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM sessions
+			WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		)
+	`, sessionID).Scan(&active)
+	return err == nil && active
+}
+
+// ✅ 指定セッションを失効させる（ログアウト時）
+func RevokeSession(db *sql.DB, sessionID string) error {
+	_, err := db.Exec(`UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, sessionID)
+	return err
+}
+
+// ✅ ユーザーのセッションを exceptSessionID 以外すべて失効させる
+// exceptSessionID が空文字の場合は全セッションが対象
+func RevokeUserSessions(db *sql.DB, userID int, exceptSessionID string) error {
+	_, err := db.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`, userID, exceptSessionID)
+	return err
+}
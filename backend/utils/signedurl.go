@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 署名付き添付 URL のプレフィックス（/files/{期限}/{署名}/{キー}）
+const SignedFilePrefix = "/files/"
+
+var (
+	fileURLSecretOnce sync.Once
+	fileURLSecret     []byte
+)
+
+// ✅ 署名用の秘密鍵（ATTACHMENT_URL_SECRET、未設定なら起動ごとにランダム生成）
+func fileURLKey() []byte {
+	fileURLSecretOnce.Do(func() {
+		if v := os.Getenv("ATTACHMENT_URL_SECRET"); v != "" {
+			fileURLSecret = []byte(v)
+			return
+		}
+		log.Println("⚠️ ATTACHMENT_URL_SECRET が未設定のため一時的な鍵を使用します（再起動で URL が無効になります）")
+		fileURLSecret = make([]byte, 32)
+		if _, err := rand.Read(fileURLSecret); err != nil {
+			panic(err)
+		}
+	})
+	return fileURLSecret
+}
+
+// 署名付き URL の有効期間（ATTACHMENT_URL_TTL、例: "30m"。デフォルト 1時間）
+func FileURLTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ATTACHMENT_URL_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Hour
+}
+
+func fileURLSignature(key string, exp int64) string {
+	mac := hmac.New(sha256.New, fileURLKey())
+	mac.Write([]byte(strconv.FormatInt(exp, 10) + "\n" + key))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ストレージのキーに対する短期間有効な URL パスを生成する
+// 期限は TTL 単位で切り上げるので、同じ時間帯に発行した URL は同一になる（ブラウザキャッシュが効く）
+func SignFileURL(key string) string {
+	ttl := FileURLTTL()
+	exp := time.Now().Truncate(ttl).Add(2 * ttl).Unix()
+	return SignedFilePrefix + strconv.FormatInt(exp, 10) + "/" + fileURLSignature(key, exp) + "/" + EscapePath(key)
+}
+
+// 署名と期限を検証する
+func VerifyFileURL(key, expStr, sig string) (time.Time, bool) {
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expiresAt := time.Unix(exp, 0)
+	if time.Now().After(expiresAt) {
+		return expiresAt, false
+	}
+	return expiresAt, hmac.Equal([]byte(sig), []byte(fileURLSignature(key, exp)))
+}
+
+// "/" 区切りのキーを URL パスとしてエスケープする（"/" はそのまま）
+func EscapePath(key string) string {
+	var b []byte
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b = append(b, c)
+		} else {
+			b = append(b, '%', "0123456789ABCDEF"[c>>4], "0123456789ABCDEF"[c&15])
+		}
+	}
+	return string(b)
+}
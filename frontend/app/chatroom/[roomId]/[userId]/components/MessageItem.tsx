@@ -6,9 +6,9 @@ interface Props {
     sender: string;
     content: string;
     thread_root_id?: number | null;
-    attachment?: string;
+    attachment?: string; attachment_type?: string;
   };
-  quotedMessage?: { sender: string; content: string; attachment?: string };
+  quotedMessage?: { sender: string; content: string; attachment?: string; attachment_type?: string };
   isSender: boolean;
   readers: string[];
   reactions: { emoji: string; users: string[] }[];
@@ -16,7 +16,7 @@ interface Props {
   currentUser: string;
   actionBoxRefs: React.MutableRefObject<Map<number, HTMLDivElement | null>>;
   setActionBoxVisible: React.Dispatch<React.SetStateAction<number | null>>;
-  setReplyTo: React.Dispatch<React.SetStateAction<{ id: number; content: string; sender: string ;thread_root_id?: number;attachment?: string; } | null>>;
+  setReplyTo: React.Dispatch<React.SetStateAction<{ id: number; content: string; sender: string ;thread_root_id?: number;attachment?: string; attachment_type?: string; } | null>>;
   handleHide: (id: number) => void;
   handleRevoke: (id: number) => void;
   handleReaction: (id: number, emoji: string) => void;
@@ -88,7 +88,7 @@ export default function MessageItem({
             <div className="mb-2 px-2 py-1 bg-white/30 rounded text-xs text-white border border-white/40">
               <span className="font-bold"></span>
               {quotedMessage.attachment ? (
-                quotedMessage.attachment.match(/\.(jpg|jpeg|png|gif)$/i)
+                quotedMessage.attachment_type?.startsWith("image/")
                   ? "｜画像"
                   : "｜ファイル"
               ) : (
@@ -111,15 +111,15 @@ export default function MessageItem({
             ))}
           </div>
 
-          {msg.attachment && msg.attachment.match(/\.(jpg|jpeg|png|gif)$/i) ? (
+          {msg.attachment && msg.attachment_type?.startsWith("image/") ? (
             <div className="relative inline-block mt-2 group">
               <img
-                src={`http://localhost:8081${msg.attachment.startsWith("/uploads/") ? msg.attachment : `/uploads/${msg.attachment}`}`}
+                src={`http://localhost:8081${msg.attachment}`}
                 alt="attachment"
                 className="rounded shadow max-w-full h-auto"
               />
               <a
-                href={`http://localhost:8081/downloads/${msg.attachment.replace("/uploads/", "")}`}
+                href={`http://localhost:8081${msg.attachment}?download=1`}
                 className="absolute top-1 right-1 bg-black/60 hover:bg-black/80 text-white text-xs px-2 py-1 rounded-full opacity-0 group-hover:opacity-100 transition"
                 title="画像を保存"
               >
@@ -128,7 +128,7 @@ export default function MessageItem({
             </div>
           ) : msg.attachment ? (
             <a
-              href={`http://localhost:8081${msg.attachment.startsWith("/uploads/") ? msg.attachment : `/uploads/${msg.attachment}`}`}
+              href={`http://localhost:8081${msg.attachment}?download=1`}
               download
               target="_self"
               className="text-blue-200 underline text-sm block mt-2"
@@ -9,6 +9,8 @@ interface RoomInfo {
   id: number;
   room_name: string;
   is_group: boolean;
+  user_id?: number; // 1対1ルームの相手
+  username?: string;
 }
 
 export default function UserPage() {
@@ -18,7 +20,7 @@ export default function UserPage() {
 
   const [users, setUsers] = useState<string[]>([]);
   const [message, setMessage] = useState(""); 
-  const [messages, setMessages] = useState<{ id: number; content: string; sender: string; readers?: string[];thread_root_id?: number | null; attachment?: string }[]>([]);
+  const [messages, setMessages] = useState<{ id: number; content: string; sender: string; readers?: string[];thread_root_id?: number | null; attachment?: string; attachment_type?: string }[]>([]);
 
   const [checking, setChecking] = useState(true);
   const [error, setError] = useState<string | null>(null);
@@ -39,7 +41,7 @@ export default function UserPage() {
   const [messageReactions, setMessageReactions] = useState<Record<number, { emoji: string; users: string[] }[]>>({});
   const [showEmojiPicker, setShowEmojiPicker] = useState(false);
    
-  const [replyTo, setReplyTo] = useState<{id: number; content: string; sender: string; thread_root_id?: number; attachment?: string;} | null>(null);
+  const [replyTo, setReplyTo] = useState<{id: number; content: string; sender: string; thread_root_id?: number; attachment?: string; attachment_type?: string;} | null>(null);
   
   //  メッセージの既読ユーザーを取得する非同期関数
   const fetchReads = async () => {
@@ -74,12 +76,8 @@ export default function UserPage() {
     const newUserToRoomIdMap: Record<string, number> = {};
 
     for (const room of matchedRooms) {
-      if (!room.room_name.includes("_") || !currentUser) continue;
-      const parts = room.room_name.split("_"); 
-      const me = currentUser;
-      const otherUser = parts.find((name) => name !== me);
-      if (otherUser) {
-        newUserToRoomIdMap[otherUser] = room.id;
+      if (room.username) {
+        newUserToRoomIdMap[room.username] = room.id;
       }
     }
     // console.log("✅ userToRoomIdMap 正確建立 =", newUserToRoomIdMap);
@@ -121,7 +119,7 @@ export default function UserPage() {
         "Content-Type": "application/json",
       },
       credentials: "include",
-      body: JSON.stringify({ user1: currentUser, user2: targetUser }),
+      body: JSON.stringify({ username: targetUser }),
     });
 
     const data = await res.json();
@@ -219,11 +217,12 @@ export default function UserPage() {
 
   //ファイルアップロード処理
   const handleFileUpload = async (e: React.ChangeEvent<HTMLInputElement>) => {
-    const file = e.target.files?.[0];
-    if (!file || !roomId) return;
+    const files = Array.from(e.target.files ?? []);
+    if (files.length === 0 || !roomId) return;
 
+    // 複数ファイルを1つのメッセージとして送信
     const formData = new FormData();
-    formData.append("file", file);
+    files.forEach((file) => formData.append("file", file));
     formData.append("room_id", roomId.toString());
     formData.append("type", "file");
 
@@ -365,6 +364,7 @@ export default function UserPage() {
                 content: parsed.parent_message.content,
                 thread_root_id: parsed.parent_message.thread_root_id,
                 attachment: parsed.parent_message.attachment || undefined,
+                attachment_type: parsed.parent_message.attachments?.[0]?.content_type,
               });
             }
 
@@ -382,6 +382,7 @@ export default function UserPage() {
                       content: parentMsg.content,
                       thread_root_id: parentMsg.thread_root_id,
                       attachment: parentMsg.attachment || undefined,
+                      attachment_type: parentMsg.attachments?.[0]?.content_type,
                     },
                   ]);
                 });
@@ -393,6 +394,7 @@ export default function UserPage() {
             content: msg.content,
             thread_root_id: msg.thread_root_id,
             attachment: msg.attachment || undefined,
+            attachment_type: msg.attachments?.[0]?.content_type,
           });
 
           return newMessages;
@@ -538,6 +540,7 @@ export default function UserPage() {
               sender: m.sender,
               thread_root_id: m.thread_root_id,
               attachment: m.attachment || undefined,
+              attachment_type: m.attachments?.[0]?.content_type,
             });
           }
         }
@@ -697,7 +700,7 @@ export default function UserPage() {
                   handleRevoke={handleRevoke}
                   handleReaction={handleReaction}
                   quotedMessage={
-                    root ? { sender: root.sender, content: root.content,attachment: root.attachment,} : undefined
+                    root ? { sender: root.sender, content: root.content,attachment: root.attachment, attachment_type: root.attachment_type,} : undefined
                   }
                 />
               );
@@ -721,7 +724,7 @@ export default function UserPage() {
             <div className="flex items-end">
               {/* 左下、画像など */}
               <div className="flex flex-col justify-end mr-2">
-                <input type="file" id="file-upload" style={{ display: "none" }} onChange={handleFileUpload} />
+                <input type="file" id="file-upload" multiple style={{ display: "none" }} onChange={handleFileUpload} />
                 <input type="file" accept="image/*" id="image-upload" style={{ display: "none" }} onChange={handleImageUpload} />
                 <div className="relative flex space-x-2 text-xl text-gray-600">
                   <button onClick={() => document.getElementById("file-upload")?.click()} title="ファイル">📎</button>
@@ -756,7 +759,7 @@ export default function UserPage() {
                       <span>
                         ↩ {replyTo.sender}：
                         {replyTo.attachment ? (
-                          replyTo.attachment.match(/\.(jpg|jpeg|png|gif)$/i)
+                          replyTo.attachment_type?.startsWith("image/")
                             ? "｜画像"
                             : "｜ファイル"
                         ) : replyTo.content}
@@ -6,6 +6,8 @@ interface RoomInfo {
   id: number;
   room_name: string;
   is_group: boolean;
+  user_id?: number; // 1対1ルームの相手
+  username?: string;
 }
 
 export default function ChatRoomWithUserPage() {
@@ -32,7 +34,7 @@ export default function ChatRoomWithUserPage() {
       method: "POST",
       headers: { "Content-Type": "application/json" },
       credentials: "include",
-      body: JSON.stringify({ user1: currentUser, user2: targetUser }),
+      body: JSON.stringify({ username: targetUser }),
     });
 
     if (!res.ok) {
@@ -78,8 +80,7 @@ export default function ChatRoomWithUserPage() {
 
     const mapping: Record<string, number> = {};
     matchedRooms.forEach((room) => {
-      const other = room.room_name.split("_").find((n) => n !== currentUser);
-      if (other) mapping[other] = room.id;
+      if (room.username) mapping[room.username] = room.id;
     });
 
     setUserToRoomIdMap(mapping);
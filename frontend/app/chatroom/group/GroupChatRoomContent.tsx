@@ -19,7 +19,7 @@ export default function GroupChatRoomContent() {
   const [roomTitle, setRoomTitle] = useState<string>("グループチャット");
   const messagesEndRef = useRef<HTMLDivElement>(null);
   const [messageReads, setMessageReads] = useState<Record<number, string[]>>({});
-  const [messages, setMessages] = useState<{ id: number; content: string; sender: string; thread_root_id?: number | null; attachment?: string;}[]>([]);
+  const [messages, setMessages] = useState<{ id: number; content: string; sender: string; thread_root_id?: number | null; attachment?: string; attachment_type?: string;}[]>([]);
 
   const [webSocketStatus, setWebSocketStatus] = useState<string>("undefined");
   const [systemMessage, setSystemMessage] = useState<string | null>(null);
@@ -35,7 +35,7 @@ export default function GroupChatRoomContent() {
   const [mentions, setMentions] = useState<string[]>([]); 
   const [showMentionList, setShowMentionList] = useState(false); 
   const [cursorPos, setCursorPos] = useState<number>(0); 
-  const [replyTo, setReplyTo] = useState<{id: number; content: string; sender: string; thread_root_id?: number; attachment?: string;} | null>(null);
+  const [replyTo, setReplyTo] = useState<{id: number; content: string; sender: string; thread_root_id?: number; attachment?: string; attachment_type?: string;} | null>(null);
 
   //  メッセージの既読ユーザーを取得する非同期関数
   const fetchReads = async () => {
@@ -163,11 +163,12 @@ export default function GroupChatRoomContent() {
 
   //通常ファイルをアップロードする処理
   const handleFileUpload = async (e: React.ChangeEvent<HTMLInputElement>) => {
-    const file = e.target.files?.[0];
-    if (!file || !roomId) return;
+    const files = Array.from(e.target.files ?? []);
+    if (files.length === 0 || !roomId) return;
 
+    // 複数ファイルを1つのメッセージとして送信
     const formData = new FormData();
-    formData.append("file", file);
+    files.forEach((file) => formData.append("file", file));
     formData.append("room_id", roomId.toString());
     formData.append("type", "file");
 
@@ -228,7 +229,7 @@ export default function GroupChatRoomContent() {
             content: string;
             sender: string;
             thread_root_id?: number | null;
-            attachment?: string;
+            attachment?: string; attachment_type?: string;
           }[] = [];
 
           const userEmojiMap: { [messageId: number]: { [user: string]: string } } = {};
@@ -274,6 +275,7 @@ export default function GroupChatRoomContent() {
                 sender: m.sender,
                 thread_root_id: m.thread_root_id,
                 attachment: m.attachment || undefined,
+                attachment_type: m.attachments?.[0]?.content_type,
               });
             }
           }
@@ -369,6 +371,7 @@ export default function GroupChatRoomContent() {
                 content: parsed.parent_message.content,
                 thread_root_id: parsed.parent_message.thread_root_id,
                 attachment: parsed.parent_message.attachment || undefined,
+                attachment_type: parsed.parent_message.attachments?.[0]?.content_type,
               });
             }
           }
@@ -380,6 +383,7 @@ export default function GroupChatRoomContent() {
             content: msg.content,
             thread_root_id: msg.thread_root_id,
             attachment: msg.attachment || undefined,
+            attachment_type: msg.attachments?.[0]?.content_type,
           });
 
           return newMessages;
@@ -420,7 +424,7 @@ export default function GroupChatRoomContent() {
               content: string;
               sender: string;
               thread_root_id?: number | null;
-              attachment?: string;
+              attachment?: string; attachment_type?: string;
             }[] = [];
 
             const userEmojiMap: { [messageId: number]: { [user: string]: string } } = {};
@@ -467,6 +471,7 @@ export default function GroupChatRoomContent() {
                   sender: m.sender,
                   thread_root_id: m.thread_root_id,
                   attachment: m.attachment || undefined,
+                  attachment_type: m.attachments?.[0]?.content_type,
                 });
               }
             }
@@ -646,7 +651,7 @@ export default function GroupChatRoomContent() {
                   handleRevoke={handleRevoke}
                   handleReaction={handleReaction}
                   quotedMessage={
-                    root ? { sender: root.sender, content: root.content,attachment: root.attachment,} : undefined
+                    root ? { sender: root.sender, content: root.content,attachment: root.attachment, attachment_type: root.attachment_type,} : undefined
                   }
                 />
               );
@@ -672,7 +677,7 @@ export default function GroupChatRoomContent() {
             <div className="flex items-end">
               {/* 功能按鈕列（左下） */}
               <div className="flex flex-col justify-end mr-2">
-                <input type="file" id="file-upload" style={{ display: "none" }} onChange={handleFileUpload} />
+                <input type="file" id="file-upload" multiple style={{ display: "none" }} onChange={handleFileUpload} />
                 <input type="file" accept="image/*" id="image-upload" style={{ display: "none" }} onChange={handleImageUpload} />
 
                 <div className="relative flex space-x-2 text-xl text-gray-600">
@@ -708,7 +713,7 @@ export default function GroupChatRoomContent() {
                       <span>
                         ↩ {replyTo.sender}：
                         {replyTo.attachment ? (
-                          replyTo.attachment.match(/\.(jpg|jpeg|png|gif)$/i)
+                          replyTo.attachment_type?.startsWith("image/")
                             ? "｜画像"
                             : "｜ファイル"
                         ) : replyTo.content}
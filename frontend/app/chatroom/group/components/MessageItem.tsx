@@ -6,9 +6,9 @@ interface Props {
     sender: string;
     content: string;
     thread_root_id?: number | null;
-    attachment?: string;
+    attachment?: string; attachment_type?: string;
   };
-  quotedMessage?: { sender: string; content: string; attachment?: string };
+  quotedMessage?: { sender: string; content: string; attachment?: string; attachment_type?: string };
   isSender: boolean;
   readers: string[];
   reactions: { emoji: string; users: string[] }[];
@@ -16,7 +16,7 @@ interface Props {
   currentUser: string;
   actionBoxRefs: React.MutableRefObject<Map<number, HTMLDivElement | null>>;
   setActionBoxVisible: React.Dispatch<React.SetStateAction<number | null>>;
-  setReplyTo: React.Dispatch<React.SetStateAction<{ id: number; content: string; sender: string ;thread_root_id?: number;attachment?: string; } | null>>;
+  setReplyTo: React.Dispatch<React.SetStateAction<{ id: number; content: string; sender: string ;thread_root_id?: number;attachment?: string; attachment_type?: string; } | null>>;
   handleHide: (id: number) => void;
   handleRevoke: (id: number) => void;
   handleReaction: (id: number, emoji: string) => void;
@@ -69,7 +69,7 @@ const onlySelfRead = readers.length === 1 && readers[0] === currentUser;
               )}
               <button
                 onClick={() => {
-                  setReplyTo({ id: msg.id, content: msg.content, sender: msg.sender ,thread_root_id: msg.thread_root_id ?? undefined, attachment: msg.attachment,});
+                  setReplyTo({ id: msg.id, content: msg.content, sender: msg.sender ,thread_root_id: msg.thread_root_id ?? undefined, attachment: msg.attachment, attachment_type: msg.attachment_type,});
                   setActionBoxVisible(null);
                 }}
                 className="mt-2 text-[#2e8b57] hover:text-[#1a5e3b] transition"
@@ -91,7 +91,7 @@ const onlySelfRead = readers.length === 1 && readers[0] === currentUser;
             <div className="mb-2 px-2 py-1 bg-white/30 rounded text-xs text-white border border-white/40">
               <span className="font-bold">@{quotedMessage.sender}</span>：
               {quotedMessage.attachment ? (
-                quotedMessage.attachment.match(/\.(jpg|jpeg|png|gif)$/i)
+                quotedMessage.attachment_type?.startsWith("image/")
                   ? "｜画像"
                   : "｜ファイル"
               ) : (
@@ -114,15 +114,15 @@ const onlySelfRead = readers.length === 1 && readers[0] === currentUser;
             ))}
           </div>
 
-          {msg.attachment && msg.attachment.match(/\.(jpg|jpeg|png|gif)$/i) ? (
+          {msg.attachment && msg.attachment_type?.startsWith("image/") ? (
             <div className="relative inline-block mt-2 group">
               <img
-                src={`http://localhost:8081${msg.attachment.startsWith("/uploads/") ? msg.attachment : `/uploads/${msg.attachment}`}`}
+                src={`http://localhost:8081${msg.attachment}`}
                 alt="attachment"
                 className="rounded shadow max-w-full h-auto"
               />
               <a
-                href={`http://localhost:8081/downloads/${msg.attachment.replace("/uploads/", "")}`}
+                href={`http://localhost:8081${msg.attachment}?download=1`}
                 className="absolute top-1 right-1 bg-black/60 hover:bg-black/80 text-white text-xs px-2 py-1 rounded-full opacity-0 group-hover:opacity-100 transition"
                 title="画像を保存"
               >
@@ -131,7 +131,7 @@ const onlySelfRead = readers.length === 1 && readers[0] === currentUser;
             </div>
           ) : msg.attachment ? (
             <a
-              href={`http://localhost:8081${msg.attachment.startsWith("/uploads/") ? msg.attachment : `/uploads/${msg.attachment}`}`}
+              href={`http://localhost:8081${msg.attachment}?download=1`}
               download
               target="_self"
               className="text-blue-200 underline text-sm block mt-2"